@@ -2,6 +2,8 @@ package main
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
 	"io"
 	"net"
@@ -29,15 +31,32 @@ import (
 var githubClient *github.Client
 
 func init() {
-	accessToken, loaded := os.LookupEnv("ACCESS_TOKEN")
-	if !loaded {
+	accessToken := os.Getenv("ACCESS_TOKEN")
+	if accessToken == "" {
 		githubClient = github.NewClient(nil)
 		return
 	}
-	transport := &github.BasicAuthTransport{
-		Username: accessToken,
+	authenticatedClient := *http.DefaultClient
+	authenticatedClient.Transport = &tokenTransport{accessToken, http.DefaultClient.Transport}
+	githubClient = github.NewClient(&authenticatedClient)
+}
+
+// tokenTransport authenticates requests with a bearer token, BasicAuthTransport
+// with an empty password is not honored as token authentication. Requests go
+// through base, http.DefaultTransport if nil.
+type tokenTransport struct {
+	token string
+	base  http.RoundTripper
+}
+
+func (t *tokenTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	request = request.Clone(request.Context())
+	request.Header.Set("Authorization", "Bearer "+t.token)
+	base := t.base
+	if base == nil {
+		base = http.DefaultTransport
 	}
-	githubClient = github.NewClient(transport.Client())
+	return base.RoundTrip(request)
 }
 
 func fetch(from string) (*github.RepositoryRelease, error) {
@@ -263,7 +282,26 @@ func release(source string, destination string, output string, ruleSetOutput str
 }
 
 func setActionOutput(name string, content string) {
-	os.Stdout.WriteString("::set-output name=" + name + "::" + content + "\n")
+	outputPath := os.Getenv("GITHUB_OUTPUT")
+	if outputPath == "" {
+		os.Stdout.WriteString("::set-output name=" + name + "::" + content + "\n")
+		return
+	}
+	outputFile, err := os.OpenFile(outputPath, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		log.Warn("open GITHUB_OUTPUT ", outputPath, ": ", err)
+		os.Stdout.WriteString("::set-output name=" + name + "::" + content + "\n")
+		return
+	}
+	defer outputFile.Close()
+	if !strings.Contains(content, "\n") {
+		outputFile.WriteString(name + "=" + content + "\n")
+		return
+	}
+	var delimiterBytes [8]byte
+	rand.Read(delimiterBytes[:])
+	delimiter := "EOF_" + hex.EncodeToString(delimiterBytes[:])
+	outputFile.WriteString(name + "<<" + delimiter + "\n" + content + "\n" + delimiter + "\n")
 }
 
 func main() {
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/sagernet/sing/common"
+
+	"github.com/google/go-github/v45/github"
+	"sing-geosite/geositegen"
+)
+
+// loadPreviousManifest reads the manifest left in the rule set output by the
+// last run, falling back to the manifest.json asset of the destination release.
+func loadPreviousManifest(ctx context.Context, downloader *geositegen.Downloader, destinationRelease *github.RepositoryRelease, options Options) (geositegen.Manifest, bool) {
+	manifest, err := geositegen.ReadManifest(filepath.Join(options.RuleSetOutput, "manifest.json"))
+	if err == nil {
+		return manifest, true
+	} else if !os.IsNotExist(err) {
+		geositegen.Warn("read previous manifest", geositegen.F("error", err))
+	}
+	if destinationRelease == nil {
+		return geositegen.Manifest{}, false
+	}
+	manifestAsset := common.Find(destinationRelease.Assets, func(it *github.ReleaseAsset) bool {
+		return it.GetName() == "manifest.json"
+	})
+	if manifestAsset == nil {
+		return geositegen.Manifest{}, false
+	}
+	content, err := downloader.Get(ctx, manifestAsset.GetBrowserDownloadURL())
+	if err != nil {
+		geositegen.Warn("download previous manifest", geositegen.F("error", err))
+		return geositegen.Manifest{}, false
+	}
+	err = json.Unmarshal(content, &manifest)
+	if err != nil {
+		geositegen.Warn("decode previous manifest", geositegen.F("error", err))
+		return geositegen.Manifest{}, false
+	}
+	return manifest, true
+}
+
+func reportChanges(previous geositegen.Manifest, current []geositegen.ManifestEntry, options Options) error {
+	changes := geositegen.Diff(previous.RuleSets, current)
+	for _, entry := range changes.Added {
+		geositegen.Info("added", geositegen.F("code", entry.Code), geositegen.F("rules", entry.RuleCount))
+	}
+	for _, entry := range changes.Removed {
+		geositegen.Info("removed", geositegen.F("code", entry.Code), geositegen.F("rules", entry.RuleCount))
+	}
+	for _, change := range changes.Changed {
+		geositegen.Info("changed", geositegen.F("code", change.Code), geositegen.F("previous_rules", change.PreviousRuleCount), geositegen.F("rules", change.RuleCount))
+	}
+	if options.ChangesOutput == "" {
+		return nil
+	}
+	changesPath, _ := filepath.Abs(options.ChangesOutput)
+	geositegen.LogFile("write", changesPath)
+	return geositegen.WriteFileAtomic(options.ChangesOutput, []byte(changes.Markdown()))
+}
+
+// writeSummary writes the geositegen.Summary of the run to the summary file,
+// previous is nil if there is no previous manifest.
+func writeSummary(sources []provenanceSource, previous *geositegen.Manifest, current []geositegen.ManifestEntry, options Options) error {
+	sourceTags := common.Map(sources, func(it provenanceSource) string {
+		if it.Tag == "" {
+			return it.Asset
+		}
+		return it.Repository + "@" + it.Tag
+	})
+	summaryPath, _ := filepath.Abs(options.SummaryFile)
+	geositegen.LogFile("write", summaryPath)
+	return geositegen.WriteFileAtomic(options.SummaryFile, []byte(geositegen.Summary(strings.Join(sourceTags, ", "), previous, current)))
+}
+
+// previousRuleSets returns the entries of the manifest left in ruleSetOutput
+// by the last run by code, every rule set is rebuilt if there is none.
+func previousRuleSets(ruleSetOutput string) map[string]geositegen.ManifestEntry {
+	manifest, err := geositegen.ReadManifest(filepath.Join(ruleSetOutput, "manifest.json"))
+	if err != nil {
+		if !os.IsNotExist(err) {
+			geositegen.Warn("read previous manifest", geositegen.F("error", err))
+		}
+		geositegen.Info("no previous manifest, rebuilding every rule set")
+		return nil
+	}
+	previous := make(map[string]geositegen.ManifestEntry, len(manifest.RuleSets))
+	for _, entry := range manifest.RuleSets {
+		previous[entry.Code] = entry
+	}
+	return previous
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+
+	"sing-geosite/geositegen"
+)
+
+// dryRun reports how the rule counts would change against the manifest
+// present in ruleSetOutput without writing anything.
+func dryRun(ruleSetOutput string, ruleCounts map[string]int) error {
+	previousCounts := make(map[string]int)
+	manifest, err := geositegen.ReadManifest(filepath.Join(ruleSetOutput, "manifest.json"))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for _, entry := range manifest.RuleSets {
+		previousCounts[entry.Code] = entry.RuleCount
+	}
+	var totalRules int
+	codes := make([]string, 0, len(ruleCounts))
+	for code, count := range ruleCounts {
+		codes = append(codes, code)
+		totalRules += count
+	}
+	sort.Strings(codes)
+	geositegen.Info("dry run", geositegen.F("path", ruleSetOutput), geositegen.F("codes", len(codes)), geositegen.F("rules", totalRules))
+	for _, code := range codes {
+		previousCount, loaded := previousCounts[code]
+		count := ruleCounts[code]
+		if !loaded {
+			geositegen.Info("dry run: added", geositegen.F("code", code), geositegen.F("rules", count))
+		} else if count > previousCount {
+			geositegen.Info("dry run: gained", geositegen.F("code", code), geositegen.F("rules", count-previousCount))
+		} else if count < previousCount {
+			geositegen.Info("dry run: lost", geositegen.F("code", code), geositegen.F("rules", previousCount-count))
+		}
+	}
+	removedCodes := make([]string, 0)
+	for code := range previousCounts {
+		if _, loaded := ruleCounts[code]; !loaded {
+			removedCodes = append(removedCodes, code)
+		}
+	}
+	sort.Strings(removedCodes)
+	for _, code := range removedCodes {
+		geositegen.Info("dry run: removed", geositegen.F("code", code), geositegen.F("rules", previousCounts[code]))
+	}
+	return nil
+}
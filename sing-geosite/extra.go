@@ -0,0 +1,42 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+
+	E "github.com/sagernet/sing/common/exceptions"
+
+	"sing-geosite/geositegen"
+)
+
+// readExtraDomains reads a json object mapping codes to the domains added to
+// them, see geositegen.ExtraDomains.
+func readExtraDomains(path string, options geositegen.ParseOptions) (geositegen.Hook, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, E.Cause(err, "read extra domains")
+	}
+	var extra map[string][]string
+	err = json.Unmarshal(content, &extra)
+	if err != nil {
+		return nil, E.Cause(err, "decode extra domains ", path)
+	}
+	hook, err := geositegen.ExtraDomains(extra, options)
+	if err != nil {
+		return nil, E.Cause(err, "decode extra domains ", path)
+	}
+	return hook, nil
+}
+
+// readDenyList reads the geositegen.DenyList at path.
+func readDenyList(path string) (*geositegen.DenyList, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, E.Cause(err, "read deny list")
+	}
+	denyList, err := geositegen.ParseDenyList(content)
+	if err != nil {
+		return nil, E.Cause(err, "decode deny list ", path)
+	}
+	return denyList, nil
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"time"
+
+	"github.com/sagernet/sing-box/option"
+	"github.com/sagernet/sing/common"
+
+	"sing-geosite/geositegen"
+)
+
+// generateGeoIP writes the geoip rule sets from the geoip.dat downloaded to
+// dataPath.
+func generateGeoIP(dataPath string, options Options, metrics *runMetrics) error {
+	start := time.Now()
+	vData, err := os.ReadFile(dataPath)
+	if err != nil {
+		return err
+	}
+	prefixMap, err := geositegen.ParseGeoIP(vData)
+	if err != nil {
+		return err
+	}
+	switch options.IPVersion {
+	case "4":
+		prefixMap = geositegen.FilterIPVersion(prefixMap, 4)
+	case "6":
+		prefixMap = geositegen.FilterIPVersion(prefixMap, 6)
+	}
+	if options.SplitIPVersion {
+		geositegen.SplitIPVersion(prefixMap)
+	}
+	measure(&metrics.Parse, &start)
+	if options.DryRun {
+		ruleCounts := make(map[string]int, len(prefixMap))
+		for code, prefixes := range prefixMap {
+			ruleCounts[code] = len(prefixes)
+		}
+		return dryRun(options.GeoIPRuleSetOutput, ruleCounts)
+	}
+	ruleSetWriter, err := geositegen.NewRuleSetWriter(options.GeoIPRuleSetOutput, options.Force)
+	if err != nil {
+		return err
+	}
+	ruleSetWriter.Gzip = options.Gzip
+	ruleSetWriter.Sort = options.Sort
+	ruleSetWriter.Verify = options.Verify
+	ruleSetWriter.VerifyOutput = options.VerifyOutput
+	ruleSetWriter.SkipSRS = !common.Contains(options.Formats, "srs")
+	ruleSetWriter.SkipJSON = !common.Contains(options.Formats, "json")
+	ruleSetWriter.JSONIndent = options.JSONIndent
+	ruleSetWriter.PruneEmpty = options.PruneEmpty
+	manifestEntries, err := ruleSetWriter.WriteRuleSets("geoip-", geositegen.SortedCodes(prefixMap), options.Concurrency, func(code string) option.DefaultHeadlessRule {
+		prefixes := prefixMap[code]
+		var headlessRule option.DefaultHeadlessRule
+		headlessRule.IPCIDR = make([]string, 0, len(prefixes))
+		for _, prefix := range prefixes {
+			headlessRule.IPCIDR = append(headlessRule.IPCIDR, prefix.String())
+		}
+		return headlessRule
+	})
+	if err != nil {
+		return err
+	}
+	if emptyCodes := ruleSetWriter.EmptyCodes(); len(emptyCodes) > 0 {
+		geositegen.Info("pruned empty codes", geositegen.F("codes", strings.Join(emptyCodes, ",")))
+	}
+	err = ruleSetWriter.WriteManifest(manifestEntries)
+	if err != nil {
+		return err
+	}
+	err = ruleSetWriter.Close()
+	if err != nil {
+		return err
+	}
+	measure(&metrics.Generate, &start)
+	metrics.WrittenFiles += ruleSetWriter.WrittenFiles()
+	return nil
+}
@@ -0,0 +1,254 @@
+package geositegen
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/sagernet/sing-box/common/geosite"
+	"github.com/sagernet/sing/common"
+	E "github.com/sagernet/sing/common/exceptions"
+
+	"github.com/v2fly/v2ray-core/v5/app/router/routercommon"
+)
+
+// ParseArchive converts a zip or tar archive of plain-text domain lists, one
+// code per file in the domain-list-community format, into the same items as
+// Parse. Only files below a data directory are read if the archive has one.
+func ParseArchive(archivePath string, options ParseOptions) (map[string][]geosite.Item, error) {
+	files, err := readArchive(archivePath)
+	if err != nil {
+		return nil, err
+	}
+	var dataFiles map[string][]byte
+	for name, content := range files {
+		if !strings.Contains("/"+path.Dir(name)+"/", "/data/") {
+			continue
+		}
+		if dataFiles == nil {
+			dataFiles = make(map[string][]byte)
+		}
+		dataFiles[name] = content
+	}
+	if dataFiles != nil {
+		files = dataFiles
+	}
+	lists := make(map[string][]listRule)
+	for name, content := range files {
+		code := strings.ToLower(path.Base(name))
+		if _, loaded := lists[code]; loaded {
+			return nil, E.New("duplicate list ", code, " in ", archivePath)
+		}
+		rules, err := parseList(content)
+		if err != nil {
+			return nil, E.Cause(err, "parse ", name)
+		}
+		lists[code] = rules
+	}
+	codes := SortedCodes(lists)
+	vGeositeEntries := make([]*routercommon.GeoSite, 0, len(codes))
+	for _, code := range codes {
+		domains, err := resolveList(lists, code, nil)
+		if err != nil {
+			return nil, err
+		}
+		vGeositeEntries = append(vGeositeEntries, &routercommon.GeoSite{
+			CountryCode: code,
+			Domain:      domains,
+		})
+	}
+	return convertEntries(vGeositeEntries, options), nil
+}
+
+func readArchive(archivePath string) (map[string][]byte, error) {
+	file, err := os.Open(archivePath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	reader := bufio.NewReader(file)
+	magic, _ := reader.Peek(4)
+	switch {
+	case bytes.Equal(magic, []byte("PK\x03\x04")):
+		info, err := file.Stat()
+		if err != nil {
+			return nil, err
+		}
+		return readZip(file, info.Size())
+	case bytes.HasPrefix(magic, []byte{0x1f, 0x8b}):
+		gzipReader, err := gzip.NewReader(reader)
+		if err != nil {
+			return nil, err
+		}
+		defer gzipReader.Close()
+		return readTar(gzipReader)
+	default:
+		return readTar(reader)
+	}
+}
+
+func readZip(reader io.ReaderAt, size int64) (map[string][]byte, error) {
+	zipReader, err := zip.NewReader(reader, size)
+	if err != nil {
+		return nil, err
+	}
+	files := make(map[string][]byte)
+	for _, zipFile := range zipReader.File {
+		if !zipFile.Mode().IsRegular() {
+			continue
+		}
+		fileReader, err := zipFile.Open()
+		if err != nil {
+			return nil, err
+		}
+		content, err := io.ReadAll(fileReader)
+		fileReader.Close()
+		if err != nil {
+			return nil, E.Cause(err, "read ", zipFile.Name)
+		}
+		files[zipFile.Name] = content
+	}
+	return files, nil
+}
+
+func readTar(reader io.Reader) (map[string][]byte, error) {
+	tarReader := tar.NewReader(reader)
+	files := make(map[string][]byte)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			return files, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		content, err := io.ReadAll(tarReader)
+		if err != nil {
+			return nil, E.Cause(err, "read ", header.Name)
+		}
+		files[header.Name] = content
+	}
+}
+
+type listRule struct {
+	include    string
+	domain     *routercommon.Domain
+	attributes []string
+}
+
+// parseList parses a domain list, each line is a rule optionally prefixed by
+// full:, domain:, keyword:, regexp: or include: and followed by @attributes.
+func parseList(content []byte) ([]listRule, error) {
+	var rules []listRule
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		var rule listRule
+		for _, field := range fields[1:] {
+			if !strings.HasPrefix(field, "@") || len(field) == 1 {
+				return nil, E.New("line ", lineNumber, ": invalid attribute ", field)
+			}
+			rule.attributes = append(rule.attributes, strings.ToLower(field[1:]))
+		}
+		ruleType, value, found := strings.Cut(fields[0], ":")
+		if !found {
+			ruleType, value = "domain", fields[0]
+		}
+		if ruleType == "include" {
+			rule.include = strings.ToLower(value)
+			rules = append(rules, rule)
+			continue
+		}
+		domainType, loaded := ParseDomainType(ruleType)
+		if !loaded {
+			return nil, E.New("line ", lineNumber, ": unknown rule type ", ruleType)
+		}
+		rule.domain = &routercommon.Domain{
+			Type:  domainType,
+			Value: value,
+		}
+		for _, attribute := range rule.attributes {
+			rule.domain.Attribute = append(rule.domain.Attribute, &routercommon.Domain_Attribute{
+				Key:        attribute,
+				TypedValue: &routercommon.Domain_Attribute_BoolValue{BoolValue: true},
+			})
+		}
+		rules = append(rules, rule)
+	}
+	return rules, scanner.Err()
+}
+
+// ParseDomainType returns the domain type of a rule type of the
+// domain-list-community format, domain, full, keyword or regexp.
+func ParseDomainType(name string) (routercommon.Domain_Type, bool) {
+	switch name {
+	case "domain":
+		return routercommon.Domain_RootDomain, true
+	case "full":
+		return routercommon.Domain_Full, true
+	case "keyword":
+		return routercommon.Domain_Plain, true
+	case "regexp":
+		return routercommon.Domain_Regex, true
+	}
+	return 0, false
+}
+
+// resolveList returns the domains of code with includes expanded, an include
+// carrying @attribute keeps only domains with it and @-attribute only those
+// without it.
+func resolveList(lists map[string][]listRule, code string, parents []string) ([]*routercommon.Domain, error) {
+	for _, parent := range parents {
+		if parent == code {
+			return nil, E.New("include cycle: ", strings.Join(append(parents, code), " -> "))
+		}
+	}
+	rules, loaded := lists[code]
+	if !loaded {
+		return nil, E.New("include of missing list ", code, " from ", parents[len(parents)-1])
+	}
+	var domains []*routercommon.Domain
+	for _, rule := range rules {
+		if rule.include == "" {
+			domains = append(domains, rule.domain)
+			continue
+		}
+		includedDomains, err := resolveList(lists, rule.include, append(parents, code))
+		if err != nil {
+			return nil, err
+		}
+		for _, domain := range includedDomains {
+			if matchAttributes(domain, rule.attributes) {
+				domains = append(domains, domain)
+			}
+		}
+	}
+	return domains, nil
+}
+
+func matchAttributes(domain *routercommon.Domain, filters []string) bool {
+	for _, filter := range filters {
+		exclude := strings.HasPrefix(filter, "-")
+		key := strings.TrimPrefix(filter, "-")
+		found := common.Any(domain.Attribute, func(attribute *routercommon.Domain_Attribute) bool {
+			return attribute.Key == key
+		})
+		if found == exclude {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,63 @@
+package geositegen
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// AtomicFile is written as a temporary file next to its destination and
+// renamed into place by Commit, so readers never see a partial file.
+type AtomicFile struct {
+	*os.File
+	path      string
+	committed bool
+}
+
+func CreateAtomic(path string) (*AtomicFile, error) {
+	file, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return nil, err
+	}
+	return &AtomicFile{File: file, path: path}, nil
+}
+
+// Commit closes the file and renames it to its destination.
+func (f *AtomicFile) Commit() error {
+	err := f.File.Close()
+	if err != nil {
+		return err
+	}
+	err = os.Chmod(f.File.Name(), 0o644)
+	if err != nil {
+		return err
+	}
+	err = os.Rename(f.File.Name(), f.path)
+	if err != nil {
+		return err
+	}
+	f.committed = true
+	return nil
+}
+
+// Close discards the file unless it was committed.
+func (f *AtomicFile) Close() error {
+	if f.committed {
+		return nil
+	}
+	f.File.Close()
+	return os.Remove(f.File.Name())
+}
+
+// WriteFileAtomic is os.WriteFile through an AtomicFile.
+func WriteFileAtomic(path string, content []byte) error {
+	file, err := CreateAtomic(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, err = file.Write(content)
+	if err != nil {
+		return err
+	}
+	return file.Commit()
+}
@@ -0,0 +1,126 @@
+package geositegen
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	E "github.com/sagernet/sing/common/exceptions"
+)
+
+// WriteBundle writes the files below directory into a zip archive if
+// bundlePath ends with .zip, a gzip compressed tar archive otherwise. Entries
+// are sorted by path and carry no modification time, so the archive only
+// depends on the content. Hidden directories are skipped.
+func WriteBundle(directory string, bundlePath string) error {
+	names, err := bundleFiles(directory)
+	if err != nil {
+		return err
+	}
+	path, _ := filepath.Abs(bundlePath)
+	LogFile("write", path)
+	file, err := CreateAtomic(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if strings.HasSuffix(bundlePath, ".zip") {
+		err = writeZip(file, directory, names)
+	} else {
+		err = writeTarGzip(file, directory, names)
+	}
+	if err != nil {
+		return err
+	}
+	return file.Commit()
+}
+
+func bundleFiles(directory string) ([]string, error) {
+	var names []string
+	err := filepath.WalkDir(directory, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			if path != directory && strings.HasPrefix(entry.Name(), ".") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !entry.Type().IsRegular() {
+			return nil
+		}
+		name, err := filepath.Rel(directory, path)
+		if err != nil {
+			return err
+		}
+		names = append(names, filepath.ToSlash(name))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func writeTarGzip(writer io.Writer, directory string, names []string) error {
+	gzipWriter, err := gzip.NewWriterLevel(writer, gzip.BestCompression)
+	if err != nil {
+		return err
+	}
+	tarWriter := tar.NewWriter(gzipWriter)
+	for _, name := range names {
+		content, err := os.ReadFile(filepath.Join(directory, filepath.FromSlash(name)))
+		if err != nil {
+			return err
+		}
+		err = tarWriter.WriteHeader(&tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     name,
+			Mode:     0o644,
+			Size:     int64(len(content)),
+			Format:   tar.FormatPAX,
+		})
+		if err != nil {
+			return E.Cause(err, "write ", name)
+		}
+		_, err = tarWriter.Write(content)
+		if err != nil {
+			return E.Cause(err, "write ", name)
+		}
+	}
+	err = tarWriter.Close()
+	if err != nil {
+		return err
+	}
+	return gzipWriter.Close()
+}
+
+func writeZip(writer io.Writer, directory string, names []string) error {
+	zipWriter := zip.NewWriter(writer)
+	for _, name := range names {
+		content, err := os.ReadFile(filepath.Join(directory, filepath.FromSlash(name)))
+		if err != nil {
+			return err
+		}
+		entryWriter, err := zipWriter.CreateHeader(&zip.FileHeader{
+			Name:   name,
+			Method: zip.Deflate,
+		})
+		if err != nil {
+			return E.Cause(err, "write ", name)
+		}
+		_, err = entryWriter.Write(content)
+		if err != nil {
+			return E.Cause(err, "write ", name)
+		}
+	}
+	return zipWriter.Close()
+}
@@ -0,0 +1,114 @@
+package geositegen
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type Changes struct {
+	Added   []ManifestEntry
+	Removed []ManifestEntry
+	Changed []CodeChange
+}
+
+type CodeChange struct {
+	Code              string
+	PreviousRuleCount int
+	RuleCount         int
+}
+
+// Diff compares two manifests by code, a code counts as changed when its rule
+// set differs, even with the same rule count.
+func Diff(previous []ManifestEntry, current []ManifestEntry) Changes {
+	previousEntries := make(map[string]ManifestEntry, len(previous))
+	for _, entry := range previous {
+		previousEntries[entry.Code] = entry
+	}
+	currentEntries := make(map[string]ManifestEntry, len(current))
+	for _, entry := range current {
+		currentEntries[entry.Code] = entry
+	}
+	var changes Changes
+	for _, entry := range current {
+		previousEntry, loaded := previousEntries[entry.Code]
+		if !loaded {
+			changes.Added = append(changes.Added, entry)
+		} else if ruleSetChanged(previousEntry, entry) {
+			changes.Changed = append(changes.Changed, CodeChange{
+				Code:              entry.Code,
+				PreviousRuleCount: previousEntry.RuleCount,
+				RuleCount:         entry.RuleCount,
+			})
+		}
+	}
+	for _, entry := range previous {
+		if _, loaded := currentEntries[entry.Code]; !loaded {
+			changes.Removed = append(changes.Removed, entry)
+		}
+	}
+	sort.Slice(changes.Added, func(i, j int) bool {
+		return changes.Added[i].Code < changes.Added[j].Code
+	})
+	sort.Slice(changes.Removed, func(i, j int) bool {
+		return changes.Removed[i].Code < changes.Removed[j].Code
+	})
+	sort.Slice(changes.Changed, func(i, j int) bool {
+		return changes.Changed[i].Code < changes.Changed[j].Code
+	})
+	return changes
+}
+
+// ruleSetChanged compares the items of a code by their hash when both entries
+// record it, by the rule set file otherwise.
+func ruleSetChanged(previous ManifestEntry, current ManifestEntry) bool {
+	if previous.RuleCount != current.RuleCount {
+		return true
+	}
+	if previous.ContentSHA256 != "" && current.ContentSHA256 != "" {
+		return previous.ContentSHA256 != current.ContentSHA256
+	}
+	return previous.SHA256 != current.SHA256
+}
+
+func (c Changes) IsEmpty() bool {
+	return len(c.Added) == 0 && len(c.Removed) == 0 && len(c.Changed) == 0
+}
+
+func (c Changes) Markdown() string {
+	var builder strings.Builder
+	builder.WriteString("## Changes\n\n")
+	if c.IsEmpty() {
+		builder.WriteString("No code was added, removed or changed.\n")
+		return builder.String()
+	}
+	if len(c.Added) > 0 {
+		builder.WriteString("### Added\n\n")
+		for _, entry := range c.Added {
+			builder.WriteString("- `" + entry.Code + "`: " + strconv.Itoa(entry.RuleCount) + " rules\n")
+		}
+		builder.WriteString("\n")
+	}
+	if len(c.Removed) > 0 {
+		builder.WriteString("### Removed\n\n")
+		for _, entry := range c.Removed {
+			builder.WriteString("- `" + entry.Code + "`: " + strconv.Itoa(entry.RuleCount) + " rules\n")
+		}
+		builder.WriteString("\n")
+	}
+	if len(c.Changed) > 0 {
+		builder.WriteString("### Changed\n\n")
+		for _, change := range c.Changed {
+			delta := change.RuleCount - change.PreviousRuleCount
+			deltaString := strconv.Itoa(delta)
+			if delta > 0 {
+				deltaString = "+" + deltaString
+			} else if delta == 0 {
+				deltaString = "items changed"
+			}
+			builder.WriteString("- `" + change.Code + "`: " + strconv.Itoa(change.PreviousRuleCount) + " → " + strconv.Itoa(change.RuleCount) + " (" + deltaString + ")\n")
+		}
+		builder.WriteString("\n")
+	}
+	return builder.String()
+}
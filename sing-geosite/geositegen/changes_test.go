@@ -0,0 +1,34 @@
+package geositegen
+
+import "testing"
+
+func TestDiff(t *testing.T) {
+	previous := []ManifestEntry{
+		{Code: "same", RuleCount: 2, SHA256: "a", ContentSHA256: "1"},
+		{Code: "count", RuleCount: 2, SHA256: "b", ContentSHA256: "2"},
+		{Code: "items", RuleCount: 2, SHA256: "c", ContentSHA256: "3"},
+		{Code: "file", RuleCount: 2, SHA256: "d"},
+		{Code: "removed", RuleCount: 1},
+	}
+	current := []ManifestEntry{
+		{Code: "same", RuleCount: 2, SHA256: "a", ContentSHA256: "1"},
+		{Code: "count", RuleCount: 3, SHA256: "e", ContentSHA256: "4"},
+		{Code: "items", RuleCount: 2, SHA256: "f", ContentSHA256: "5"},
+		{Code: "file", RuleCount: 2, SHA256: "g", ContentSHA256: "6"},
+		{Code: "added", RuleCount: 1},
+	}
+	changes := Diff(previous, current)
+	if len(changes.Added) != 1 || changes.Added[0].Code != "added" {
+		t.Errorf("unexpected added codes: %v", changes.Added)
+	}
+	if len(changes.Removed) != 1 || changes.Removed[0].Code != "removed" {
+		t.Errorf("unexpected removed codes: %v", changes.Removed)
+	}
+	var changed []string
+	for _, change := range changes.Changed {
+		changed = append(changed, change.Code)
+	}
+	if len(changed) != 3 || changed[0] != "count" || changed[1] != "file" || changed[2] != "items" {
+		t.Errorf("unexpected changed codes: %v, expected [count file items]", changed)
+	}
+}
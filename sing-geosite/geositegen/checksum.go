@@ -0,0 +1,42 @@
+package geositegen
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"hash"
+	"strings"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+// ChecksumAlgorithm describes a checksum file published next to an asset as
+// asset.Extension in the sha256sum output format.
+type ChecksumAlgorithm struct {
+	Name      string
+	Extension string
+	New       func() hash.Hash
+	Size      int
+}
+
+// ChecksumAlgorithms lists the supported algorithms, strongest first.
+var ChecksumAlgorithms = []ChecksumAlgorithm{
+	{Name: "sha512", Extension: "sha512sum", New: sha512.New, Size: sha512.Size},
+	{Name: "blake2b", Extension: "b2sum", New: newBLAKE2b, Size: blake2b.Size},
+	{Name: "sha256", Extension: "sha256sum", New: sha256.New, Size: sha256.Size},
+}
+
+func newBLAKE2b() hash.Hash {
+	hasher, _ := blake2b.New512(nil)
+	return hasher
+}
+
+// ChecksumAlgorithmOf returns the algorithm of the checksum file name by its
+// extension.
+func ChecksumAlgorithmOf(name string) (ChecksumAlgorithm, bool) {
+	for _, algorithm := range ChecksumAlgorithms {
+		if strings.HasSuffix(name, "."+algorithm.Extension) {
+			return algorithm, true
+		}
+	}
+	return ChecksumAlgorithm{}, false
+}
@@ -0,0 +1,123 @@
+package geositegen
+
+import (
+	"bufio"
+	"bytes"
+	"strings"
+
+	"github.com/sagernet/sing-box/common/geosite"
+	E "github.com/sagernet/sing/common/exceptions"
+)
+
+// DenyList matches the items never to be written, listed one per line in the
+// domain-list-community syntax. A domain: entry, the default without prefix,
+// matches the domain and suffix items of the domain and its subdomains, full:,
+// keyword: and regexp: entries match the item of the same type and value.
+// Empty lines and lines starting with # are skipped.
+type DenyList struct {
+	domains  map[string]bool
+	suffixes map[string]bool
+	keywords map[string]bool
+	regexes  map[string]bool
+}
+
+// ParseDenyList parses a deny list, errors name the offending line.
+func ParseDenyList(content []byte) (*DenyList, error) {
+	denyList := &DenyList{
+		domains:  make(map[string]bool),
+		suffixes: make(map[string]bool),
+		keywords: make(map[string]bool),
+		regexes:  make(map[string]bool),
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		typeName, value, loaded := strings.Cut(line, ":")
+		if !loaded {
+			typeName, value = "domain", line
+		}
+		switch typeName {
+		case "domain":
+			value = strings.Trim(strings.ToLower(value), ".")
+			if !isHostname(value) {
+				return nil, E.New("line ", lineNumber, ": invalid domain ", value)
+			}
+			denyList.suffixes[value] = true
+		case "full":
+			value = strings.Trim(strings.ToLower(value), ".")
+			if !isHostname(value) {
+				return nil, E.New("line ", lineNumber, ": invalid domain ", value)
+			}
+			denyList.domains[value] = true
+		case "keyword":
+			denyList.keywords[strings.ToLower(value)] = true
+		case "regexp":
+			denyList.regexes[value] = true
+		default:
+			return nil, E.New("line ", lineNumber, ": unknown type ", typeName, ", expected domain, full, keyword or regexp")
+		}
+	}
+	return denyList, scanner.Err()
+}
+
+// Match reports whether item is denied.
+func (l *DenyList) Match(item geosite.Item) bool {
+	switch item.Type {
+	case geosite.RuleTypeDomain:
+		return l.domains[item.Value] || l.matchSuffix(item.Value)
+	case geosite.RuleTypeDomainSuffix:
+		return l.matchSuffix(strings.TrimPrefix(item.Value, "."))
+	case geosite.RuleTypeDomainKeyword:
+		return l.keywords[item.Value]
+	case geosite.RuleTypeDomainRegex:
+		return l.regexes[item.Value]
+	}
+	return false
+}
+
+// matchSuffix reports whether domain or one of its parents is a denied suffix.
+func (l *DenyList) matchSuffix(domain string) bool {
+	for {
+		if l.suffixes[domain] {
+			return true
+		}
+		_, parent, loaded := strings.Cut(domain, ".")
+		if !loaded {
+			return false
+		}
+		domain = parent
+	}
+}
+
+// Hook returns a Hook removing the denied items from every code, logging the
+// number removed from each. Codes left without items are dropped.
+func (l *DenyList) Hook() Hook {
+	return func(domainMap map[string][]geosite.Item) error {
+		var removed int
+		for _, code := range SortedCodes(domainMap) {
+			items := domainMap[code]
+			kept := make([]geosite.Item, 0, len(items))
+			for _, item := range items {
+				if !l.Match(item) {
+					kept = append(kept, item)
+				}
+			}
+			if len(kept) == len(items) {
+				continue
+			}
+			Info("denied domains", F("code", code), F("removed", len(items)-len(kept)))
+			removed += len(items) - len(kept)
+			if len(kept) == 0 {
+				Warn("denied every domain, dropped code", F("code", code))
+				delete(domainMap, code)
+				continue
+			}
+			domainMap[code] = kept
+		}
+		Info("denied domains", F("removed", removed))
+		return nil
+	}
+}
@@ -0,0 +1,73 @@
+package geositegen
+
+import (
+	"testing"
+
+	"github.com/sagernet/sing-box/common/geosite"
+)
+
+func TestDenyList(t *testing.T) {
+	denyList, err := ParseDenyList([]byte("# false positives\nexample.com\nfull:www.example.org\nkeyword:ads\n\nregexp:^ads\\.\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, testCase := range []struct {
+		item   geosite.Item
+		denied bool
+	}{
+		{geosite.Item{Type: geosite.RuleTypeDomain, Value: "example.com"}, true},
+		{geosite.Item{Type: geosite.RuleTypeDomain, Value: "www.example.com"}, true},
+		{geosite.Item{Type: geosite.RuleTypeDomainSuffix, Value: ".example.com"}, true},
+		{geosite.Item{Type: geosite.RuleTypeDomainSuffix, Value: ".cdn.example.com"}, true},
+		{geosite.Item{Type: geosite.RuleTypeDomainSuffix, Value: ".com"}, false},
+		{geosite.Item{Type: geosite.RuleTypeDomain, Value: "notexample.com"}, false},
+		{geosite.Item{Type: geosite.RuleTypeDomain, Value: "www.example.org"}, true},
+		{geosite.Item{Type: geosite.RuleTypeDomainSuffix, Value: ".www.example.org"}, false},
+		{geosite.Item{Type: geosite.RuleTypeDomain, Value: "example.org"}, false},
+		{geosite.Item{Type: geosite.RuleTypeDomainKeyword, Value: "ads"}, true},
+		{geosite.Item{Type: geosite.RuleTypeDomainKeyword, Value: "example"}, false},
+		{geosite.Item{Type: geosite.RuleTypeDomainRegex, Value: `^ads\.`}, true},
+	} {
+		if denied := denyList.Match(testCase.item); denied != testCase.denied {
+			t.Errorf("%v: denied %v, expected %v", testCase.item, denied, testCase.denied)
+		}
+	}
+}
+
+func TestDenyListInvalid(t *testing.T) {
+	_, err := ParseDenyList([]byte("bad..example.com\n"))
+	if err == nil {
+		t.Fatal("expected an error for an invalid domain")
+	}
+	_, err = ParseDenyList([]byte("include:other\n"))
+	if err == nil {
+		t.Fatal("expected an error for an unknown type")
+	}
+}
+
+func TestDenyListHook(t *testing.T) {
+	denyList, err := ParseDenyList([]byte("example.com\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	domainMap := map[string][]geosite.Item{
+		"mixed": {
+			{Type: geosite.RuleTypeDomainSuffix, Value: ".example.com"},
+			{Type: geosite.RuleTypeDomainSuffix, Value: ".example.org"},
+		},
+		"denied": {
+			{Type: geosite.RuleTypeDomain, Value: "example.com"},
+			{Type: geosite.RuleTypeDomainSuffix, Value: ".example.com"},
+		},
+	}
+	err = denyList.Hook()(domainMap)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(domainMap["mixed"]) != 1 || domainMap["mixed"][0].Value != ".example.org" {
+		t.Errorf("mixed: unexpected items: %v", domainMap["mixed"])
+	}
+	if _, loaded := domainMap["denied"]; loaded {
+		t.Error("denied: expected the emptied code to be dropped")
+	}
+}
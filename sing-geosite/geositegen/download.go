@@ -0,0 +1,675 @@
+package geositegen
+
+import (
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"hash"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/sagernet/sing/common"
+	E "github.com/sagernet/sing/common/exceptions"
+
+	"github.com/google/go-github/v45/github"
+)
+
+type Downloader struct {
+	Client      *http.Client
+	MaxAttempts int
+	// CacheDir keeps verified assets keyed by their SHA256, disabled if empty.
+	CacheDir string
+	// AllowUnverified accepts assets published without a checksum asset.
+	AllowUnverified bool
+	// ChecksumWarn only warns when an asset does not match its checksum
+	// asset, which may lag behind the asset for a while upstream. Such assets
+	// are not cached.
+	ChecksumWarn bool
+	// Progress receives a progress indicator of each download, disabled if
+	// nil. Concurrent downloads take turns writing to it.
+	Progress io.Writer
+	// MaxConcurrent bounds the requests in flight across concurrent
+	// downloads, unlimited if below 1.
+	MaxConcurrent int
+	// MaxBytes fails responses whose body exceeds it, be it announced by
+	// Content-Length or not, unlimited if below 1.
+	MaxBytes int64
+
+	downloadedBytes int64
+	limitOnce       sync.Once
+	limit           chan struct{}
+	progressAccess  sync.Mutex
+}
+
+// DownloadedBytes returns the number of response body bytes read so far,
+// including those of failed attempts.
+func (d *Downloader) DownloadedBytes() int64 {
+	return atomic.LoadInt64(&d.downloadedBytes)
+}
+
+// NewHTTPClient returns a client whose requests fail when connecting or
+// awaiting the response headers takes longer than timeout, or when the body
+// stalls for as long, however long the whole body takes.
+func NewHTTPClient(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{
+		Timeout:   timeout,
+		KeepAlive: 30 * time.Second,
+	}
+	return &http.Client{
+		Transport: &idleTimeoutTransport{
+			base: &http.Transport{
+				Proxy:                 http.ProxyFromEnvironment,
+				DialContext:           dialer.DialContext,
+				ForceAttemptHTTP2:     true,
+				MaxIdleConns:          100,
+				IdleConnTimeout:       90 * time.Second,
+				TLSHandshakeTimeout:   10 * time.Second,
+				ExpectContinueTimeout: 1 * time.Second,
+				ResponseHeaderTimeout: timeout,
+			},
+			timeout: timeout,
+		},
+	}
+}
+
+// idleTimeoutTransport closes a response body once no data arrived for
+// timeout, which fails the pending read.
+type idleTimeoutTransport struct {
+	base    http.RoundTripper
+	timeout time.Duration
+}
+
+func (t *idleTimeoutTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	response, err := t.base.RoundTrip(request)
+	if err != nil {
+		return nil, err
+	}
+	body := &idleTimeoutBody{ReadCloser: response.Body, timeout: t.timeout}
+	body.timer = time.AfterFunc(t.timeout, body.expire)
+	response.Body = body
+	return response, nil
+}
+
+type idleTimeoutBody struct {
+	io.ReadCloser
+	timeout time.Duration
+	timer   *time.Timer
+	expired int32
+}
+
+func (b *idleTimeoutBody) Read(p []byte) (n int, err error) {
+	n, err = b.ReadCloser.Read(p)
+	if atomic.LoadInt32(&b.expired) != 0 {
+		return n, E.New("no data received for ", b.timeout)
+	}
+	if n > 0 {
+		b.timer.Reset(b.timeout)
+	}
+	return
+}
+
+func (b *idleTimeoutBody) expire() {
+	atomic.StoreInt32(&b.expired, 1)
+	b.ReadCloser.Close()
+}
+
+func (b *idleTimeoutBody) Close() error {
+	b.timer.Stop()
+	return b.ReadCloser.Close()
+}
+
+// Download fetches assetName from release into a temporary file and verifies
+// it against checksumAssetName, the strongest of the assetName checksum files
+// in ChecksumAlgorithms if empty, the caller removes the file.
+func (d *Downloader) Download(ctx context.Context, release *github.RepositoryRelease, assetName string, checksumAssetName string) (dataPath string, err error) {
+	dataAsset := common.Find(release.Assets, func(it *github.ReleaseAsset) bool {
+		return *it.Name == assetName
+	})
+	if dataAsset == nil {
+		return "", E.New(assetName, " asset not found in upstream release ", release.GetTagName(), ", available assets: ", assetNames(release))
+	}
+	checksumAssetNames := []string{checksumAssetName}
+	if checksumAssetName == "" {
+		checksumAssetNames = common.Map(ChecksumAlgorithms, func(it ChecksumAlgorithm) string {
+			return assetName + "." + it.Extension
+		})
+	}
+	var checksumAsset *github.ReleaseAsset
+	for _, name := range checksumAssetNames {
+		checksumAsset = common.Find(release.Assets, func(it *github.ReleaseAsset) bool {
+			return *it.Name == name
+		})
+		if checksumAsset != nil {
+			break
+		}
+	}
+	if checksumAsset == nil {
+		if !d.AllowUnverified {
+			return "", E.New("checksum asset ", strings.Join(checksumAssetNames, " or "), " not found in upstream release ", release.GetTagName(), ", available assets: ", assetNames(release))
+		}
+		Warn("checksum asset not found, download without verification", F("asset", strings.Join(checksumAssetNames, ",")))
+		dataPath, _, err = d.GetFile(ctx, *dataAsset.BrowserDownloadURL, sha256.New)
+		if err == nil {
+			warnSmall(assetName, dataPath)
+		}
+		return
+	}
+	algorithm, loaded := ChecksumAlgorithmOf(checksumAsset.GetName())
+	if !loaded {
+		return "", E.New("unknown checksum algorithm of ", checksumAsset.GetName())
+	}
+	defer func() {
+		if err != nil && dataPath != "" {
+			os.Remove(dataPath)
+			dataPath = ""
+		}
+	}()
+	var (
+		checksum  []byte
+		dataErr   error
+		waitGroup sync.WaitGroup
+	)
+	if d.CacheDir == "" {
+		// Nothing to look up in the cache, fetch the data along with its
+		// checksum.
+		waitGroup.Add(1)
+		go func() {
+			defer waitGroup.Done()
+			dataPath, checksum, dataErr = d.GetFile(ctx, *dataAsset.BrowserDownloadURL, algorithm.New)
+		}()
+	}
+	remoteChecksum, err := d.Get(ctx, *checksumAsset.BrowserDownloadURL)
+	waitGroup.Wait()
+	if err != nil || dataErr != nil {
+		return dataPath, E.Errors(err, dataErr)
+	}
+	expectedChecksum, err := ParseChecksum(remoteChecksum, algorithm.Size)
+	if err != nil {
+		return dataPath, E.Cause(err, "parse ", *checksumAsset.Name)
+	}
+	if d.CacheDir != "" {
+		dataPath, err = d.loadCache(algorithm, expectedChecksum)
+		if err == nil {
+			Info("use cached asset", F("asset", assetName), F(algorithm.Name, hex.EncodeToString(expectedChecksum)))
+			return dataPath, nil
+		} else if !os.IsNotExist(err) {
+			Warn("load cached asset", F("asset", assetName), F("error", err))
+		}
+		dataPath, checksum, err = d.GetFile(ctx, *dataAsset.BrowserDownloadURL, algorithm.New)
+		if err != nil {
+			return
+		}
+	}
+	if subtle.ConstantTimeCompare(checksum, expectedChecksum) != 1 {
+		if !d.ChecksumWarn {
+			return dataPath, E.New(algorithm.Name, " checksum mismatch of ", assetName, ": computed ", hex.EncodeToString(checksum), ", expected ", hex.EncodeToString(expectedChecksum))
+		}
+		Warn("CHECKSUM MISMATCH, using the asset anyway", F("asset", assetName), F("computed", hex.EncodeToString(checksum)), F("expected", hex.EncodeToString(expectedChecksum)))
+		warnSmall(assetName, dataPath)
+		return dataPath, nil
+	}
+	warnSmall(assetName, dataPath)
+	if d.CacheDir != "" {
+		err = d.storeCache(dataPath, algorithm, checksum)
+		if err != nil {
+			Warn("store cached asset", F("asset", assetName), F("error", err))
+		}
+	}
+	return dataPath, nil
+}
+
+// DownloadRequest names an asset of a release to be fetched by Download.
+type DownloadRequest struct {
+	Release       *github.RepositoryRelease
+	Asset         string
+	ChecksumAsset string
+	// Gunzip decompresses the asset once verified, its checksum asset
+	// describes the compressed bytes.
+	Gunzip bool
+}
+
+// DownloadedAsset is the result of a DownloadRequest.
+type DownloadedAsset struct {
+	// Path is the downloaded file, decompressed if requested.
+	Path string
+	// SHA256 is the hex checksum of the asset as downloaded, before any
+	// decompression.
+	SHA256 string
+}
+
+// DownloadAll runs Download for every request concurrently and returns the
+// assets in the same order, the files already downloaded are removed if any
+// request fails.
+func (d *Downloader) DownloadAll(ctx context.Context, requests []DownloadRequest) ([]DownloadedAsset, error) {
+	assets := make([]DownloadedAsset, len(requests))
+	errs := make([]error, len(requests))
+	var waitGroup sync.WaitGroup
+	for i := range requests {
+		waitGroup.Add(1)
+		go func(index int) {
+			defer waitGroup.Done()
+			request := requests[index]
+			dataPath, err := d.Download(ctx, request.Release, request.Asset, request.ChecksumAsset)
+			if err != nil {
+				errs[index] = E.Cause(err, "download ", request.Asset, " of ", request.Release.GetHTMLURL())
+				return
+			}
+			checksum, err := FileSHA256(dataPath)
+			if err != nil {
+				os.Remove(dataPath)
+				errs[index] = E.Cause(err, "hash ", request.Asset, " of ", request.Release.GetHTMLURL())
+				return
+			}
+			if request.Gunzip {
+				dataPath, err = gunzipFile(dataPath)
+				if err != nil {
+					errs[index] = E.Cause(err, "decompress ", request.Asset, " of ", request.Release.GetHTMLURL())
+					return
+				}
+			}
+			assets[index] = DownloadedAsset{dataPath, checksum}
+		}(i)
+	}
+	waitGroup.Wait()
+	err := E.Errors(errs...)
+	if err != nil {
+		for _, asset := range assets {
+			if asset.Path != "" {
+				os.Remove(asset.Path)
+			}
+		}
+		return nil, err
+	}
+	return assets, nil
+}
+
+// FileSHA256 returns the hex SHA256 of the file at path.
+func FileSHA256(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	hasher := sha256.New()
+	_, err = io.Copy(hasher, file)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+// minAssetSize is the size below which a downloaded asset is reported as
+// likely truncated or an error page.
+const minAssetSize = 1024
+
+// gunzipFile streams the gzip-compressed file at compressedPath into a new
+// temporary file and removes compressedPath.
+func gunzipFile(compressedPath string) (dataPath string, err error) {
+	defer os.Remove(compressedPath)
+	compressedFile, err := os.Open(compressedPath)
+	if err != nil {
+		return
+	}
+	defer compressedFile.Close()
+	gzipReader, err := gzip.NewReader(compressedFile)
+	if err != nil {
+		return
+	}
+	file, err := os.CreateTemp("", "sing-geosite-*")
+	if err != nil {
+		return
+	}
+	defer func() {
+		file.Close()
+		if err != nil {
+			os.Remove(file.Name())
+		}
+	}()
+	_, err = io.Copy(file, gzipReader)
+	if err != nil {
+		return
+	}
+	err = gzipReader.Close()
+	if err != nil {
+		return
+	}
+	return file.Name(), nil
+}
+
+func warnSmall(assetName string, dataPath string) {
+	info, err := os.Stat(dataPath)
+	if err == nil && info.Size() < minAssetSize {
+		Warn("unexpectedly small asset", F("asset", assetName), F("size", info.Size()))
+	}
+}
+
+func assetNames(release *github.RepositoryRelease) string {
+	if len(release.Assets) == 0 {
+		return "none"
+	}
+	return strings.Join(common.Map(release.Assets, func(it *github.ReleaseAsset) string {
+		return it.GetName()
+	}), ", ")
+}
+
+// loadCache copies the cached file with the given checksum into a temporary
+// file after verifying its content.
+func (d *Downloader) loadCache(algorithm ChecksumAlgorithm, checksum []byte) (path string, err error) {
+	cacheFile, err := os.Open(d.cachePath(algorithm, checksum))
+	if err != nil {
+		return
+	}
+	defer cacheFile.Close()
+	file, err := os.CreateTemp("", "sing-geosite-*")
+	if err != nil {
+		return
+	}
+	defer func() {
+		file.Close()
+		if err != nil {
+			os.Remove(file.Name())
+		}
+	}()
+	hasher := algorithm.New()
+	_, err = io.Copy(file, io.TeeReader(cacheFile, hasher))
+	if err != nil {
+		return
+	}
+	if subtle.ConstantTimeCompare(hasher.Sum(nil), checksum) != 1 {
+		err = E.New("cached file ", cacheFile.Name(), " is corrupt")
+		return
+	}
+	return file.Name(), nil
+}
+
+// cachePath returns where the asset with the given checksum is cached, SHA256
+// names are kept bare to reuse caches written before other algorithms.
+func (d *Downloader) cachePath(algorithm ChecksumAlgorithm, checksum []byte) string {
+	name := hex.EncodeToString(checksum)
+	if algorithm.Name != "sha256" {
+		name = algorithm.Name + "-" + name
+	}
+	return filepath.Join(d.CacheDir, name)
+}
+
+func (d *Downloader) storeCache(dataPath string, algorithm ChecksumAlgorithm, checksum []byte) error {
+	err := os.MkdirAll(d.CacheDir, 0o755)
+	if err != nil {
+		return err
+	}
+	dataFile, err := os.Open(dataPath)
+	if err != nil {
+		return err
+	}
+	defer dataFile.Close()
+	cacheFile, err := os.CreateTemp(d.CacheDir, ".download-*")
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(cacheFile, dataFile)
+	if err == nil {
+		err = cacheFile.Close()
+	} else {
+		cacheFile.Close()
+	}
+	if err != nil {
+		os.Remove(cacheFile.Name())
+		return err
+	}
+	return os.Rename(cacheFile.Name(), d.cachePath(algorithm, checksum))
+}
+
+func (d *Downloader) Get(ctx context.Context, downloadURL string) ([]byte, error) {
+	var content []byte
+	err := d.retry(ctx, downloadURL, nil, func(response *http.Response, body io.Reader) error {
+		var err error
+		content, err = io.ReadAll(body)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return content, nil
+}
+
+// GetFile streams downloadURL into a temporary file and returns its path
+// together with the checksum of the content computed by newHash. A retry
+// resumes after the bytes already received if the server accepts ranges and
+// the content keeps the same strong ETag, it starts over otherwise.
+func (d *Downloader) GetFile(ctx context.Context, downloadURL string, newHash func() hash.Hash) (path string, checksum []byte, err error) {
+	file, err := os.CreateTemp("", "sing-geosite-*")
+	if err != nil {
+		return
+	}
+	defer func() {
+		file.Close()
+		if err != nil {
+			os.Remove(file.Name())
+		}
+	}()
+	hasher := newHash()
+	var (
+		received int64
+		etag     string
+	)
+	err = d.retry(ctx, downloadURL, func(request *http.Request) {
+		if received > 0 && etag != "" {
+			request.Header.Set("Range", "bytes="+strconv.FormatInt(received, 10)+"-")
+			request.Header.Set("If-Range", etag)
+		}
+	}, func(response *http.Response, body io.Reader) error {
+		if response.StatusCode == http.StatusPartialContent {
+			if !strings.HasPrefix(response.Header.Get("Content-Range"), "bytes "+strconv.FormatInt(received, 10)+"-") {
+				etag = ""
+				return E.New("unexpected content range: ", response.Header.Get("Content-Range"))
+			}
+			Info("resume download", F("url", downloadURL), F("offset", received))
+		} else {
+			received = 0
+			hasher.Reset()
+			err := file.Truncate(0)
+			if err != nil {
+				return err
+			}
+			etag = ""
+			if response.Header.Get("Accept-Ranges") == "bytes" && !strings.HasPrefix(response.Header.Get("ETag"), "W/") {
+				etag = response.Header.Get("ETag")
+			}
+		}
+		_, err := file.Seek(received, io.SeekStart)
+		if err != nil {
+			return err
+		}
+		written, err := io.Copy(file, io.TeeReader(body, hasher))
+		received += written
+		return err
+	})
+	if err != nil {
+		return
+	}
+	return file.Name(), hasher.Sum(nil), nil
+}
+
+// retry runs getOnce until it succeeds, the error is not retryable or
+// MaxAttempts is reached. prepare, if not nil, adjusts each request.
+func (d *Downloader) retry(ctx context.Context, downloadURL string, prepare func(request *http.Request), consume func(response *http.Response, body io.Reader) error) error {
+	maxAttempts := d.MaxAttempts
+	if maxAttempts < 1 {
+		maxAttempts = 1
+	}
+	for attempt := 1; ; attempt++ {
+		retryable, err := d.getOnce(ctx, downloadURL, prepare, consume)
+		if err == nil {
+			return nil
+		}
+		if !retryable || attempt >= maxAttempts {
+			return E.Cause(err, "download ", downloadURL)
+		}
+		delay := backoff(attempt)
+		Warn("download failed, retrying", F("url", downloadURL), F("attempt", attempt), F("max_attempts", maxAttempts), F("error", err), F("delay", delay))
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return E.Cause(ctx.Err(), "download ", downloadURL)
+		}
+	}
+}
+
+func (d *Downloader) getOnce(ctx context.Context, downloadURL string, prepare func(request *http.Request), consume func(response *http.Response, body io.Reader) error) (retryable bool, err error) {
+	err = d.acquire(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer d.release()
+	Info("download", F("url", downloadURL))
+	client := d.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, downloadURL, nil)
+	if err != nil {
+		return false, err
+	}
+	if prepare != nil {
+		prepare(request)
+	}
+	response, err := client.Do(request)
+	if err != nil {
+		return ctx.Err() == nil, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode >= http.StatusInternalServerError || response.StatusCode == http.StatusRequestTimeout || response.StatusCode == http.StatusTooManyRequests {
+		return true, E.New("unexpected status: ", response.Status)
+	} else if response.StatusCode != http.StatusOK && response.StatusCode != http.StatusPartialContent {
+		return false, E.New("unexpected status: ", response.Status)
+	}
+	// GitHub serves some errors as an HTML page, which would only fail
+	// later in parsing.
+	if contentType := response.Header.Get("Content-Type"); strings.HasPrefix(contentType, "text/html") {
+		return false, E.New("unexpected content type: ", contentType)
+	}
+	// A resumed response only carries the rest of the content, the bytes
+	// before its range count against the limit too.
+	offset := resumeOffset(response)
+	if d.MaxBytes > 0 && offset+response.ContentLength > d.MaxBytes {
+		return false, E.New("content length ", offset+response.ContentLength, " exceeds the download limit of ", d.MaxBytes, " bytes")
+	}
+	Info("response", F("url", downloadURL), F("content_length", response.ContentLength))
+	var body io.Reader = &countReader{response.Body, &d.downloadedBytes}
+	if d.MaxBytes > 0 {
+		remaining := d.MaxBytes - offset
+		if remaining < 0 {
+			remaining = 0
+		}
+		body = &maxBytesReader{io.LimitReader(body, remaining+1), remaining}
+	}
+	if d.Progress != nil {
+		progress := newProgressWriter(d.Progress, &d.progressAccess, path.Base(request.URL.Path), response.ContentLength)
+		defer progress.Close()
+		body = io.TeeReader(body, progress)
+	}
+	err = consume(response, body)
+	if errors.Is(err, errMaxBytes) {
+		return false, E.New("response exceeds the download limit of ", d.MaxBytes, " bytes")
+	} else if err != nil {
+		return true, err
+	}
+	return false, nil
+}
+
+// resumeOffset returns the start of the range of a partial response, zero for
+// a complete one.
+func resumeOffset(response *http.Response) int64 {
+	if response.StatusCode != http.StatusPartialContent {
+		return 0
+	}
+	contentRange := strings.TrimPrefix(response.Header.Get("Content-Range"), "bytes ")
+	start, _, _ := strings.Cut(contentRange, "-")
+	offset, _ := strconv.ParseInt(start, 10, 64)
+	return offset
+}
+
+func (d *Downloader) acquire(ctx context.Context) error {
+	if d.MaxConcurrent < 1 {
+		return nil
+	}
+	d.limitOnce.Do(func() {
+		d.limit = make(chan struct{}, d.MaxConcurrent)
+	})
+	select {
+	case d.limit <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (d *Downloader) release() {
+	if d.MaxConcurrent >= 1 {
+		<-d.limit
+	}
+}
+
+var errMaxBytes = E.New("download limit exceeded")
+
+// maxBytesReader fails with errMaxBytes once more than remaining bytes are
+// read from reader, which is limited to one byte more.
+type maxBytesReader struct {
+	reader    io.Reader
+	remaining int64
+}
+
+func (r *maxBytesReader) Read(p []byte) (n int, err error) {
+	n, err = r.reader.Read(p)
+	r.remaining -= int64(n)
+	if r.remaining < 0 {
+		return n + int(r.remaining), errMaxBytes
+	}
+	return
+}
+
+type countReader struct {
+	io.Reader
+	count *int64
+}
+
+func (r *countReader) Read(p []byte) (n int, err error) {
+	n, err = r.Reader.Read(p)
+	atomic.AddInt64(r.count, int64(n))
+	return
+}
+
+func backoff(attempt int) time.Duration {
+	delay := time.Second << (attempt - 1)
+	return delay + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+// ParseChecksum reads the first field of a sha256sum style file as a hex
+// digest of size bytes.
+func ParseChecksum(content []byte, size int) ([]byte, error) {
+	fields := strings.Fields(string(content))
+	if len(fields) == 0 {
+		return nil, E.New("empty checksum")
+	}
+	if len(fields[0]) != size*2 {
+		return nil, E.New("invalid checksum length: ", len(fields[0]), ", expected ", size*2)
+	}
+	checksum, err := hex.DecodeString(strings.ToLower(fields[0]))
+	if err != nil {
+		return nil, E.Cause(err, "invalid checksum")
+	}
+	return checksum, nil
+}
@@ -0,0 +1,86 @@
+package geositegen
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"testing"
+)
+
+func TestDownloaderMaxBytes(t *testing.T) {
+	content := bytes.Repeat([]byte("x"), 20)
+	for _, testCase := range []struct {
+		name     string
+		maxBytes int64
+		handler  func(w http.ResponseWriter, r *http.Request, attempt int32)
+		attempts int32
+	}{
+		{"content length", 10, func(w http.ResponseWriter, r *http.Request, attempt int32) {
+			w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+			w.Write(content)
+		}, 1},
+		{"body", 10, func(w http.ResponseWriter, r *http.Request, attempt int32) {
+			// Flushing before writing the body omits Content-Length.
+			w.(http.Flusher).Flush()
+			w.Write(content)
+		}, 1},
+		{"resumed content length", 30, func(w http.ResponseWriter, r *http.Request, attempt int32) {
+			serveResumed(t, w, r, attempt, content, true)
+		}, 2},
+		{"resumed body", 30, func(w http.ResponseWriter, r *http.Request, attempt int32) {
+			serveResumed(t, w, r, attempt, content, false)
+		}, 2},
+	} {
+		t.Run(testCase.name, func(t *testing.T) {
+			var attempts int32
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				testCase.handler(w, r, atomic.AddInt32(&attempts, 1))
+			}))
+			defer server.Close()
+			downloader := &Downloader{
+				Client:      server.Client(),
+				MaxAttempts: 3,
+				MaxBytes:    testCase.maxBytes,
+			}
+			path, _, err := downloader.GetFile(context.Background(), server.URL+"/geosite.dat", sha256.New)
+			if err == nil {
+				os.Remove(path)
+				t.Fatal("expected the download limit to be exceeded")
+			}
+			if attempts != testCase.attempts {
+				t.Fatalf("unexpected attempts: %d, expected %d", attempts, testCase.attempts)
+			}
+		})
+	}
+}
+
+// serveResumed fails the first attempt after sending content, then serves the
+// same content again from the requested offset.
+func serveResumed(t *testing.T, w http.ResponseWriter, r *http.Request, attempt int32, content []byte, contentLength bool) {
+	w.Header().Set("Accept-Ranges", "bytes")
+	w.Header().Set("ETag", `"v1"`)
+	if attempt == 1 {
+		w.(http.Flusher).Flush()
+		w.Write(content)
+		w.(http.Flusher).Flush()
+		panic(http.ErrAbortHandler)
+	}
+	offset := strconv.Itoa(len(content))
+	if r.Header.Get("Range") != "bytes="+offset+"-" {
+		t.Errorf("unexpected range: %q", r.Header.Get("Range"))
+	}
+	w.Header().Set("Content-Range", "bytes "+offset+"-"+strconv.Itoa(2*len(content)-1)+"/"+strconv.Itoa(2*len(content)))
+	if contentLength {
+		w.Header().Set("Content-Length", offset)
+		w.WriteHeader(http.StatusPartialContent)
+	} else {
+		w.WriteHeader(http.StatusPartialContent)
+		w.(http.Flusher).Flush()
+	}
+	w.Write(content)
+}
@@ -0,0 +1,40 @@
+package geositegen
+
+import (
+	"encoding/json"
+	"io"
+
+	"github.com/sagernet/sing-box/common/srs"
+	C "github.com/sagernet/sing-box/constant"
+	"github.com/sagernet/sing-box/option"
+)
+
+// NewPlainRuleSet returns the rule set holding headlessRule as its only rule.
+func NewPlainRuleSet(headlessRule option.DefaultHeadlessRule) option.PlainRuleSet {
+	return option.PlainRuleSet{
+		Rules: []option.HeadlessRule{
+			{
+				Type:           C.RuleTypeDefault,
+				DefaultOptions: headlessRule,
+			},
+		},
+	}
+}
+
+// WriteSRS encodes headlessRule to writer as a binary rule set.
+func WriteSRS(writer io.Writer, headlessRule option.DefaultHeadlessRule) error {
+	return srs.Write(writer, NewPlainRuleSet(headlessRule))
+}
+
+// WriteJSON encodes headlessRule to writer as a source rule set, indented by
+// indent or minified if empty.
+func WriteJSON(writer io.Writer, headlessRule option.DefaultHeadlessRule, indent string) error {
+	return encodeJSON(writer, NewPlainRuleSet(headlessRule), indent)
+}
+
+func encodeJSON(writer io.Writer, plainRuleSet option.PlainRuleSet, indent string) error {
+	je := json.NewEncoder(writer)
+	je.SetEscapeHTML(false)
+	je.SetIndent("", indent)
+	return je.Encode(plainRuleSet)
+}
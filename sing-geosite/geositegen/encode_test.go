@@ -0,0 +1,66 @@
+package geositegen
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/sagernet/sing-box/common/srs"
+	"github.com/sagernet/sing-box/option"
+)
+
+func TestWriteSRS(t *testing.T) {
+	headlessRule := option.DefaultHeadlessRule{
+		Domain:       []string{"example.com"},
+		DomainSuffix: []string{".example.org", ".example.net"},
+	}
+	var buffer bytes.Buffer
+	err := WriteSRS(&buffer, headlessRule)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plainRuleSet, err := srs.Read(&buffer, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plainRuleSet.Rules) != 1 {
+		t.Fatalf("unexpected rules: %d, expected 1", len(plainRuleSet.Rules))
+	}
+	// srs.Read decodes domains and suffixes into the matcher only.
+	domainMatcher := plainRuleSet.Rules[0].DefaultOptions.DomainMatcher
+	if domainMatcher == nil {
+		t.Fatal("decoded no domain items")
+	}
+	for _, domain := range []string{"example.com", "www.example.org", "www.example.net"} {
+		if !domainMatcher.Match(domain) {
+			t.Errorf("decoded rule set does not match %s", domain)
+		}
+	}
+	if domainMatcher.Match("example.io") {
+		t.Error("decoded rule set matches example.io")
+	}
+}
+
+func TestWriteJSON(t *testing.T) {
+	headlessRule := option.DefaultHeadlessRule{
+		Domain:        []string{"example.com", "example.org"},
+		DomainKeyword: []string{"example"},
+	}
+	var buffer bytes.Buffer
+	err := WriteJSON(&buffer, headlessRule, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Count(buffer.Bytes(), []byte("\n")) != 1 {
+		t.Fatalf("expected minified json, got %s", buffer.Bytes())
+	}
+	var plainRuleSet option.PlainRuleSet
+	err = json.Unmarshal(buffer.Bytes(), &plainRuleSet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plainRuleSet.Rules) != 1 || !reflect.DeepEqual(plainRuleSet.Rules[0].DefaultOptions, headlessRule) {
+		t.Fatalf("unexpected rule set: %+v", plainRuleSet)
+	}
+}
@@ -0,0 +1,59 @@
+package geositegen
+
+import (
+	"path"
+	"regexp"
+
+	E "github.com/sagernet/sing/common/exceptions"
+)
+
+// FilterCodes keeps the codes matching any include pattern, or every code if
+// include is empty, then drops the codes matching any exclude pattern.
+func FilterCodes[T any](codeMap map[string]T, include []string, exclude []string) (map[string]T, error) {
+	if len(include) == 0 && len(exclude) == 0 {
+		return codeMap, nil
+	}
+	filtered := make(map[string]T)
+	for code, value := range codeMap {
+		included := len(include) == 0
+		for _, pattern := range include {
+			matched, err := path.Match(pattern, code)
+			if err != nil {
+				return nil, E.Cause(err, "invalid include pattern ", pattern)
+			}
+			if matched {
+				included = true
+				break
+			}
+		}
+		if !included {
+			continue
+		}
+		excluded := false
+		for _, pattern := range exclude {
+			matched, err := path.Match(pattern, code)
+			if err != nil {
+				return nil, E.Cause(err, "invalid exclude pattern ", pattern)
+			}
+			if matched {
+				excluded = true
+				break
+			}
+		}
+		if !excluded {
+			filtered[code] = value
+		}
+	}
+	return filtered, nil
+}
+
+// MatchCodes keeps the codes matching codeRegex.
+func MatchCodes[T any](codeMap map[string]T, codeRegex *regexp.Regexp) map[string]T {
+	matched := make(map[string]T)
+	for code, value := range codeMap {
+		if codeRegex.MatchString(code) {
+			matched[code] = value
+		}
+	}
+	return matched
+}
@@ -0,0 +1,83 @@
+package geositegen
+
+import (
+	"net/netip"
+	"strings"
+
+	E "github.com/sagernet/sing/common/exceptions"
+
+	"github.com/v2fly/v2ray-core/v5/app/router/routercommon"
+	"google.golang.org/protobuf/proto"
+)
+
+// ParseGeoIP converts a serialized v2ray GeoIPList into prefixes keyed by
+// lowercased code. A list without entries is rejected as corrupt.
+func ParseGeoIP(vGeoIPData []byte) (map[string][]netip.Prefix, error) {
+	vGeoIPList := routercommon.GeoIPList{}
+	err := proto.Unmarshal(vGeoIPData, &vGeoIPList)
+	if err != nil {
+		return nil, err
+	}
+	if len(vGeoIPList.Entry) == 0 {
+		return nil, E.New("parsed geoip contains no entries, upstream data likely corrupt")
+	}
+	prefixMap := make(map[string][]netip.Prefix)
+	for _, vGeoIPEntry := range vGeoIPList.Entry {
+		code := strings.ToLower(vGeoIPEntry.CountryCode)
+		if vGeoIPEntry.InverseMatch {
+			Warn("inverse matching geoip is not supported, skipped", F("code", code))
+			continue
+		}
+		prefixes := make([]netip.Prefix, 0, len(vGeoIPEntry.Cidr))
+		for _, cidr := range vGeoIPEntry.Cidr {
+			address, loaded := netip.AddrFromSlice(cidr.Ip)
+			if !loaded {
+				return nil, E.New("invalid ip in geoip ", code, ": ", cidr.Ip)
+			}
+			bits := int(cidr.Prefix)
+			if address.Is4In6() && bits >= 96 {
+				address, bits = address.Unmap(), bits-96
+			}
+			prefix, err := address.Prefix(bits)
+			if err != nil {
+				return nil, E.Cause(err, "invalid prefix in geoip ", code)
+			}
+			prefixes = append(prefixes, prefix)
+		}
+		prefixMap[code] = append(prefixMap[code], prefixes...)
+	}
+	return prefixMap, nil
+}
+
+// FilterIPVersion keeps the prefixes of ipVersion, 4 or 6, dropping the codes
+// left empty.
+func FilterIPVersion(prefixMap map[string][]netip.Prefix, ipVersion int) map[string][]netip.Prefix {
+	filtered := make(map[string][]netip.Prefix, len(prefixMap))
+	for code, prefixes := range prefixMap {
+		var kept []netip.Prefix
+		for _, prefix := range prefixes {
+			if prefix.Addr().Is4() == (ipVersion == 4) {
+				kept = append(kept, prefix)
+			}
+		}
+		if len(kept) > 0 {
+			filtered[code] = kept
+		}
+	}
+	return filtered
+}
+
+// SplitIPVersion adds code-v4 and code-v6 holding the prefixes of each family
+// of the codes mixing both.
+func SplitIPVersion(prefixMap map[string][]netip.Prefix) {
+	v4Map := FilterIPVersion(prefixMap, 4)
+	v6Map := FilterIPVersion(prefixMap, 6)
+	for code := range prefixMap {
+		v4Prefixes, v6Prefixes := v4Map[code], v6Map[code]
+		if len(v4Prefixes) == 0 || len(v6Prefixes) == 0 {
+			continue
+		}
+		prefixMap[code+"-v4"] = v4Prefixes
+		prefixMap[code+"-v6"] = v6Prefixes
+	}
+}
@@ -0,0 +1,64 @@
+package geositegen
+
+import (
+	"strings"
+
+	"github.com/sagernet/sing-box/common/geosite"
+	"github.com/sagernet/sing/common"
+	E "github.com/sagernet/sing/common/exceptions"
+
+	"github.com/v2fly/v2ray-core/v5/app/router/routercommon"
+)
+
+// Hook transforms the domain map of a run after parsing and before anything
+// is written, codes may be added, changed or removed in place.
+type Hook func(domainMap map[string][]geosite.Item) error
+
+// ApplyHooks runs hooks in order on domainMap, stopping at the first error.
+func ApplyHooks(domainMap map[string][]geosite.Item, hooks []Hook) error {
+	for i, hook := range hooks {
+		err := hook(domainMap)
+		if err != nil {
+			return E.Cause(err, "run hook ", i)
+		}
+	}
+	return nil
+}
+
+// ExtraDomains returns a Hook adding the domains of extra to the codes they
+// are listed under, creating missing codes. Domains use the
+// domain-list-community syntax, a domain:, full:, keyword: or regexp: prefix
+// giving the type, domain: if omitted.
+func ExtraDomains(extra map[string][]string, options ParseOptions) (Hook, error) {
+	extraMap := make(map[string][]geosite.Item, len(extra))
+	for code, values := range extra {
+		if code == "" {
+			return nil, E.New("empty code")
+		}
+		var items []geosite.Item
+		for _, value := range values {
+			domain := &routercommon.Domain{
+				Type:  routercommon.Domain_RootDomain,
+				Value: value,
+			}
+			if typeName, domainValue, loaded := strings.Cut(value, ":"); loaded {
+				if domainType, loaded := ParseDomainType(typeName); loaded {
+					domain.Type = domainType
+					domain.Value = domainValue
+				}
+			}
+			domainItems := ConvertDomain(domain, options)
+			if len(domainItems) == 0 {
+				return nil, E.New("invalid domain of ", code, ": ", value)
+			}
+			items = append(items, domainItems...)
+		}
+		extraMap[strings.ToLower(code)] = items
+	}
+	return func(domainMap map[string][]geosite.Item) error {
+		for _, code := range SortedCodes(extraMap) {
+			domainMap[code] = common.Uniq(append(domainMap[code], extraMap[code]...))
+		}
+		return nil
+	}, nil
+}
@@ -0,0 +1,56 @@
+package geositegen
+
+import (
+	"testing"
+
+	"github.com/sagernet/sing-box/common/geosite"
+	"github.com/sagernet/sing/common"
+)
+
+func TestExtraDomains(t *testing.T) {
+	hook, err := ExtraDomains(map[string][]string{
+		"Google": {"example.com", "full:www.example.org", "keyword:ads", "regexp:^ads\\."},
+		"new":    {"domain:example.net"},
+	}, ParseOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	domainMap := map[string][]geosite.Item{
+		"google": {{Type: geosite.RuleTypeDomainSuffix, Value: ".google.com"}},
+	}
+	err = ApplyHooks(domainMap, []Hook{hook})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, item := range []geosite.Item{
+		{Type: geosite.RuleTypeDomainSuffix, Value: ".google.com"},
+		{Type: geosite.RuleTypeDomain, Value: "example.com"},
+		{Type: geosite.RuleTypeDomainSuffix, Value: ".example.com"},
+		{Type: geosite.RuleTypeDomain, Value: "www.example.org"},
+		{Type: geosite.RuleTypeDomainKeyword, Value: "ads"},
+		{Type: geosite.RuleTypeDomainRegex, Value: `^ads\.`},
+	} {
+		if !common.Contains(domainMap["google"], item) {
+			t.Errorf("google: missing %v", item)
+		}
+	}
+	if len(domainMap["google"]) != 6 {
+		t.Errorf("google: unexpected items: %v", domainMap["google"])
+	}
+	if !common.Contains(domainMap["new"], geosite.Item{Type: geosite.RuleTypeDomainSuffix, Value: ".example.net"}) {
+		t.Errorf("new: unexpected items: %v", domainMap["new"])
+	}
+}
+
+func TestExtraDomainsInvalid(t *testing.T) {
+	for _, extra := range []map[string][]string{
+		{"google": {"bad..example.com"}},
+		{"google": {"regexp:("}},
+		{"": {"example.com"}},
+	} {
+		_, err := ExtraDomains(extra, ParseOptions{})
+		if err == nil {
+			t.Errorf("%v: expected an error", extra)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package geositegen
+
+import (
+	"regexp/syntax"
+	"unicode"
+)
+
+// LintRegex returns the reasons why the domain regex pattern may match
+// differently than its author expects in sing-box, which matches it against
+// the lowercased domain. The pattern is left unchanged.
+func LintRegex(pattern string) []string {
+	regex, err := syntax.Parse(pattern, syntax.Perl)
+	if err != nil {
+		return []string{"does not compile: " + err.Error()}
+	}
+	var (
+		reasons          []string
+		anchored         bool
+		uppercase        bool
+		unescapedDot     bool
+		walk             func(regex *syntax.Regexp)
+		isLiteralOrClass = func(regex *syntax.Regexp) bool {
+			return regex.Op == syntax.OpLiteral || regex.Op == syntax.OpCharClass
+		}
+	)
+	walk = func(regex *syntax.Regexp) {
+		switch regex.Op {
+		case syntax.OpBeginLine, syntax.OpBeginText, syntax.OpEndLine, syntax.OpEndText:
+			anchored = true
+		case syntax.OpLiteral:
+			if regex.Flags&syntax.FoldCase == 0 {
+				for _, char := range regex.Rune {
+					if unicode.IsUpper(char) {
+						uppercase = true
+					}
+				}
+			}
+		case syntax.OpConcat:
+			for i := 1; i+1 < len(regex.Sub); i++ {
+				op := regex.Sub[i].Op
+				if (op == syntax.OpAnyCharNotNL || op == syntax.OpAnyChar) && isLiteralOrClass(regex.Sub[i-1]) && isLiteralOrClass(regex.Sub[i+1]) {
+					unescapedDot = true
+				}
+			}
+		}
+		for _, sub := range regex.Sub {
+			walk(sub)
+		}
+	}
+	walk(regex)
+	if !anchored {
+		reasons = append(reasons, "unanchored, matches anywhere in the domain")
+	}
+	if uppercase {
+		reasons = append(reasons, "uppercase literal never matches a lowercased domain")
+	}
+	if unescapedDot {
+		reasons = append(reasons, "unescaped . between literals matches any character")
+	}
+	return reasons
+}
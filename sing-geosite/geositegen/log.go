@@ -0,0 +1,125 @@
+package geositegen
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sagernet/sing-box/log"
+	E "github.com/sagernet/sing/common/exceptions"
+)
+
+type Field struct {
+	Key   string
+	Value any
+}
+
+func F(key string, value any) Field {
+	return Field{Key: key, Value: value}
+}
+
+var (
+	logAccess sync.Mutex
+	logJSON   bool
+	logQuiet  bool
+)
+
+// SetLogFormat selects between the sing-box text logger and one json object
+// per line on stderr.
+func SetLogFormat(format string) error {
+	switch format {
+	case "", "text":
+		logJSON = false
+	case "json":
+		logJSON = true
+	default:
+		return E.New("unknown log format: ", format)
+	}
+	return nil
+}
+
+// SetQuiet suppresses the lines of LogFile if quiet.
+func SetQuiet(quiet bool) {
+	logQuiet = quiet
+}
+
+func Info(message string, fields ...Field) {
+	logMessage("info", message, fields)
+}
+
+func Warn(message string, fields ...Field) {
+	logMessage("warn", message, fields)
+}
+
+func Error(message string, fields ...Field) {
+	logMessage("error", message, fields)
+}
+
+func Fatal(message string, fields ...Field) {
+	logMessage("fatal", message, fields)
+	os.Exit(1)
+}
+
+// LogFile reports a file operation such as write or remove, kept as a bare
+// line in text format to match the historical output. Nothing is logged
+// after SetQuiet(true).
+func LogFile(action string, path string) {
+	if logQuiet {
+		return
+	}
+	if logJSON {
+		logMessage("info", action, []Field{F("path", path)})
+		return
+	}
+	logAccess.Lock()
+	defer logAccess.Unlock()
+	os.Stderr.WriteString(action + " " + path + "\n")
+}
+
+func logMessage(level string, message string, fields []Field) {
+	if logJSON {
+		entry := make(map[string]any, len(fields)+3)
+		for _, field := range fields {
+			switch value := field.Value.(type) {
+			case error:
+				entry[field.Key] = value.Error()
+			case fmt.Stringer:
+				entry[field.Key] = value.String()
+			default:
+				entry[field.Key] = value
+			}
+		}
+		entry["time"] = time.Now().UTC().Format(time.RFC3339)
+		entry["level"] = level
+		entry["msg"] = message
+		content, err := json.Marshal(entry)
+		if err != nil {
+			content, _ = json.Marshal(map[string]any{"level": level, "msg": message, "error": err.Error()})
+		}
+		logAccess.Lock()
+		defer logAccess.Unlock()
+		os.Stderr.Write(append(content, '\n'))
+		return
+	}
+	var builder strings.Builder
+	builder.WriteString(message)
+	for _, field := range fields {
+		builder.WriteString(" ")
+		builder.WriteString(field.Key)
+		builder.WriteString("=")
+		builder.WriteString(fmt.Sprint(field.Value))
+	}
+	switch level {
+	case "info":
+		log.Info(builder.String())
+	case "warn":
+		log.Warn(builder.String())
+	case "error":
+		log.Error(builder.String())
+	case "fatal":
+		log.Fatal(builder.String())
+	}
+}
@@ -0,0 +1,77 @@
+package geositegen
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"sort"
+)
+
+// The binary rule set format written by srs.Write, which takes no version, and
+// the first sing-box release able to read it.
+const (
+	RuleSetFormatVersion = 1
+	MinClientVersion     = "1.8.0"
+)
+
+type Manifest struct {
+	FormatVersion    int    `json:"format_version,omitempty"`
+	MinClientVersion string `json:"min_client_version,omitempty"`
+	// AttributeSeparator is what replaced the @ of code@attribute codes in
+	// the rule set paths.
+	AttributeSeparator string          `json:"attribute_separator,omitempty"`
+	RuleSets           []ManifestEntry `json:"rule_sets"`
+}
+
+type ManifestEntry struct {
+	Code      string `json:"code"`
+	Path      string `json:"path"`
+	Size      int64  `json:"size"`
+	SHA256    string `json:"sha256"`
+	RuleCount int    `json:"rule_count"`
+	// ContentSHA256 identifies the items and writer settings the rule set
+	// was built from, see RuleSetWriter.Previous.
+	ContentSHA256 string `json:"content_sha256,omitempty"`
+}
+
+func (e *ManifestEntry) setFile(path string, content []byte) {
+	checksum := sha256.Sum256(content)
+	e.Path = path
+	e.Size = int64(len(content))
+	e.SHA256 = hex.EncodeToString(checksum[:])
+}
+
+func (w *RuleSetWriter) WriteManifest(entries []ManifestEntry) error {
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Code < entries[j].Code
+	})
+	var buffer bytes.Buffer
+	je := json.NewEncoder(&buffer)
+	je.SetEscapeHTML(false)
+	je.SetIndent("", "    ")
+	err := je.Encode(Manifest{
+		FormatVersion:      RuleSetFormatVersion,
+		MinClientVersion:   MinClientVersion,
+		AttributeSeparator: w.AttributeSeparator,
+		RuleSets:           entries,
+	})
+	if err != nil {
+		return err
+	}
+	return w.WriteFile("manifest.json", buffer.Bytes())
+}
+
+func ReadManifest(path string) (Manifest, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return Manifest{}, err
+	}
+	var manifest Manifest
+	err = json.Unmarshal(content, &manifest)
+	if err != nil {
+		return Manifest{}, err
+	}
+	return manifest, nil
+}
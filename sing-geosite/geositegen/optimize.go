@@ -0,0 +1,59 @@
+package geositegen
+
+import (
+	"sort"
+	"strings"
+)
+
+// OptimizeSuffixes drops the domain suffixes already matched by a shorter one
+// of suffixes, keeping the order of the others. A suffix without the leading
+// dot matches the domain itself too, one with it only the subdomains.
+func OptimizeSuffixes(suffixes []string) ([]string, int) {
+	type suffixKey struct {
+		index  int
+		labels string
+		dotted bool
+	}
+	keys := make([]suffixKey, len(suffixes))
+	for i, suffix := range suffixes {
+		labels := strings.Split(strings.TrimPrefix(suffix, "."), ".")
+		for left, right := 0, len(labels)-1; left < right; left, right = left+1, right-1 {
+			labels[left], labels[right] = labels[right], labels[left]
+		}
+		keys[i] = suffixKey{
+			index:  i,
+			labels: strings.Join(labels, "\x00"),
+			dotted: strings.HasPrefix(suffix, "."),
+		}
+	}
+	// Joining reversed labels with NUL sorts every suffix right before its
+	// subdomains, the undotted form first.
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].labels != keys[j].labels {
+			return keys[i].labels < keys[j].labels
+		}
+		return !keys[i].dotted && keys[j].dotted
+	})
+	pruned := make([]bool, len(suffixes))
+	var prunedCount int
+	var last *suffixKey
+	for i := range keys {
+		key := &keys[i]
+		if last != nil && (strings.HasPrefix(key.labels, last.labels+"\x00") || key.labels == last.labels && (!last.dotted || key.dotted)) {
+			pruned[key.index] = true
+			prunedCount++
+			continue
+		}
+		last = key
+	}
+	if prunedCount == 0 {
+		return suffixes, 0
+	}
+	optimized := make([]string, 0, len(suffixes)-prunedCount)
+	for i, suffix := range suffixes {
+		if !pruned[i] {
+			optimized = append(optimized, suffix)
+		}
+	}
+	return optimized, prunedCount
+}
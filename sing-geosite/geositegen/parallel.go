@@ -0,0 +1,44 @@
+package geositegen
+
+import "sync"
+
+// parallel runs task for every index below count on at most concurrency
+// goroutines, stops dispatching after the first failure and returns it.
+func parallel(concurrency int, count int, task func(index int) error) error {
+	if concurrency > count {
+		concurrency = count
+	}
+	var (
+		waitGroup sync.WaitGroup
+		errOnce   sync.Once
+		taskErr   error
+		indexes   = make(chan int)
+		done      = make(chan struct{})
+	)
+	for i := 0; i < concurrency; i++ {
+		waitGroup.Add(1)
+		go func() {
+			defer waitGroup.Done()
+			for index := range indexes {
+				err := task(index)
+				if err != nil {
+					errOnce.Do(func() {
+						taskErr = err
+						close(done)
+					})
+				}
+			}
+		}()
+	}
+dispatch:
+	for index := 0; index < count; index++ {
+		select {
+		case indexes <- index:
+		case <-done:
+			break dispatch
+		}
+	}
+	close(indexes)
+	waitGroup.Wait()
+	return taskErr
+}
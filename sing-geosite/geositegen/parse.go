@@ -0,0 +1,331 @@
+// Package geositegen converts v2ray geosite and geoip data into sing-box
+// geosite databases and rule sets.
+package geositegen
+
+import (
+	"regexp"
+	"runtime"
+	"strings"
+
+	"github.com/sagernet/sing-box/common/geosite"
+	"github.com/sagernet/sing-box/option"
+	"github.com/sagernet/sing/common"
+	E "github.com/sagernet/sing/common/exceptions"
+
+	"github.com/v2fly/v2ray-core/v5/app/router/routercommon"
+	"google.golang.org/protobuf/proto"
+)
+
+type ParseOptions struct {
+	// SuffixOnly converts a root domain into a single suffix without the
+	// leading dot, which matches the domain itself as well, instead of an
+	// exact domain and a .suffix item.
+	SuffixOnly bool
+	// NoRegex skips regular expression domains, which are expensive to match.
+	NoRegex bool
+	// LintRegex warns about the regular expression domains LintRegex finds
+	// suspicious.
+	LintRegex bool
+	// TypeOverrides converts the domains of the given types of a code, and of
+	// its code@attribute codes, as another type.
+	TypeOverrides map[string]map[routercommon.Domain_Type]routercommon.Domain_Type
+	// Concurrency bounds the goroutines converting entries, the number of
+	// CPUs if below 1.
+	Concurrency int
+	// Duplicates, if not nil, receives the number of duplicate items removed
+	// from each code, codes without duplicates are left out.
+	Duplicates map[string]int
+}
+
+// Parse converts a serialized v2ray GeoSiteList into geosite items keyed by
+// lowercased code, domains carrying attributes are also collected under
+// code@attribute. A list without entries is rejected as corrupt.
+func Parse(vGeositeData []byte, options ParseOptions) (map[string][]geosite.Item, error) {
+	vGeositeList := routercommon.GeoSiteList{}
+	err := proto.Unmarshal(vGeositeData, &vGeositeList)
+	if err != nil {
+		return nil, err
+	}
+	if len(vGeositeList.Entry) == 0 {
+		return nil, E.New("parsed geosite contains no entries, upstream data likely corrupt")
+	}
+	return convertEntries(vGeositeList.Entry, options), nil
+}
+
+// convertedEntry is the conversion of a single v2ray GeoSite entry.
+type convertedEntry struct {
+	code       string
+	domains    []geosite.Item
+	attributes []string
+	// attributeDomains holds the items of each attribute, in the order of
+	// attributes.
+	attributeDomains [][]geosite.Item
+	dropped          int
+	removedRegex     int
+	// regexFindings holds the LintRegex reasons of each suspicious pattern.
+	regexFindings map[string][]string
+}
+
+func convertEntry(vGeositeEntry *routercommon.GeoSite, options ParseOptions) convertedEntry {
+	entry := convertedEntry{
+		code:    strings.ToLower(vGeositeEntry.CountryCode),
+		domains: make([]geosite.Item, 0, len(vGeositeEntry.Domain)*2),
+	}
+	attributes := make(map[string][]*routercommon.Domain)
+	typeOverrides := options.TypeOverrides[entry.code]
+	for _, domain := range vGeositeEntry.Domain {
+		if domainType, loaded := typeOverrides[domain.Type]; loaded {
+			domain = &routercommon.Domain{
+				Type:      domainType,
+				Value:     domain.Value,
+				Attribute: domain.Attribute,
+			}
+		}
+		if options.NoRegex && domain.Type == routercommon.Domain_Regex {
+			entry.removedRegex++
+			continue
+		}
+		if options.LintRegex && domain.Type == routercommon.Domain_Regex {
+			if reasons := LintRegex(domain.Value); len(reasons) > 0 {
+				if entry.regexFindings == nil {
+					entry.regexFindings = make(map[string][]string)
+				}
+				entry.regexFindings[domain.Value] = reasons
+			}
+		}
+		if len(domain.Attribute) > 0 {
+			for _, attribute := range domain.Attribute {
+				attributes[attribute.Key] = append(attributes[attribute.Key], domain)
+			}
+		}
+		items := ConvertDomain(domain, options)
+		if len(items) == 0 {
+			entry.dropped++
+			continue
+		}
+		entry.domains = append(entry.domains, items...)
+	}
+	entry.attributes = SortedCodes(attributes)
+	for _, attribute := range entry.attributes {
+		attributeEntries := attributes[attribute]
+		attributeDomains := make([]geosite.Item, 0, len(attributeEntries)*2)
+		for _, domain := range attributeEntries {
+			attributeDomains = append(attributeDomains, ConvertDomain(domain, options)...)
+		}
+		entry.attributeDomains = append(entry.attributeDomains, attributeDomains)
+	}
+	return entry
+}
+
+// convertEntries converts vGeositeEntries on at most options.Concurrency
+// goroutines and merges the results in the upstream order.
+func convertEntries(vGeositeEntries []*routercommon.GeoSite, options ParseOptions) map[string][]geosite.Item {
+	concurrency := options.Concurrency
+	if concurrency < 1 {
+		concurrency = runtime.NumCPU()
+	}
+	entries := make([]convertedEntry, len(vGeositeEntries))
+	parallel(concurrency, len(vGeositeEntries), func(index int) error {
+		entries[index] = convertEntry(vGeositeEntries[index], options)
+		return nil
+	})
+	domainMap := make(map[string][]geosite.Item)
+	droppedCounts := make(map[string]int)
+	regexCounts := make(map[string]int)
+	var lintedPatterns int
+	// origins records the upstream name each key was built from, to report
+	// keys overwritten by a later code or attribute.
+	origins := make(map[string]string)
+	put := func(key string, origin string, items []geosite.Item) {
+		if previous, loaded := origins[key]; loaded {
+			Warn("duplicate code, overwritten", F("code", key), F("previous", previous), F("current", origin))
+		}
+		origins[key] = origin
+		uniqueItems := common.Uniq(items)
+		if options.Duplicates != nil && len(uniqueItems) < len(items) {
+			options.Duplicates[key] = len(items) - len(uniqueItems)
+		}
+		domainMap[key] = uniqueItems
+	}
+	for index, entry := range entries {
+		countryCode := vGeositeEntries[index].CountryCode
+		if entry.dropped > 0 {
+			droppedCounts[entry.code] += entry.dropped
+		}
+		if entry.removedRegex > 0 {
+			regexCounts[entry.code] += entry.removedRegex
+		}
+		for _, pattern := range SortedCodes(entry.regexFindings) {
+			Warn("suspicious regex", F("code", entry.code), F("pattern", pattern), F("reasons", strings.Join(entry.regexFindings[pattern], "; ")))
+			lintedPatterns++
+		}
+		put(entry.code, countryCode, entry.domains)
+		for i, attribute := range entry.attributes {
+			put(entry.code+"@"+attribute, countryCode+" attribute "+attribute, entry.attributeDomains[i])
+		}
+	}
+	if lintedPatterns > 0 {
+		Warn("suspicious regex", F("patterns", lintedPatterns))
+	}
+	if len(regexCounts) > 0 {
+		var removed int
+		for _, code := range SortedCodes(regexCounts) {
+			Info("removed regex domains", F("code", code), F("removed", regexCounts[code]))
+			removed += regexCounts[code]
+		}
+		Info("removed regex domains", F("codes", len(regexCounts)), F("removed", removed))
+	}
+	if len(droppedCounts) > 0 {
+		var dropped int
+		for _, code := range SortedCodes(droppedCounts) {
+			Warn("dropped malformed domains", F("code", code), F("dropped", droppedCounts[code]))
+			dropped += droppedCounts[code]
+		}
+		Warn("dropped malformed domains", F("codes", len(droppedCounts)), F("dropped", dropped))
+	}
+	return domainMap
+}
+
+// ConvertDomain converts a v2ray domain into the equivalent geosite items,
+// a root domain containing a dot matches both itself and its subdomains.
+// Values other than regular expressions are lowercased, leading and trailing
+// dots of root and full domains are trimmed. Empty values, invalid
+// hostnames and regular expressions which do not compile yield no items.
+func ConvertDomain(domain *routercommon.Domain, options ParseOptions) []geosite.Item {
+	if domain.Value == "" {
+		return nil
+	}
+	switch domain.Type {
+	case routercommon.Domain_Plain:
+		return []geosite.Item{{
+			Type:  geosite.RuleTypeDomainKeyword,
+			Value: strings.ToLower(domain.Value),
+		}}
+	case routercommon.Domain_Regex:
+		_, err := regexp.Compile(domain.Value)
+		if err != nil {
+			return nil
+		}
+		return []geosite.Item{{
+			Type:  geosite.RuleTypeDomainRegex,
+			Value: domain.Value,
+		}}
+	case routercommon.Domain_RootDomain:
+		value := strings.Trim(strings.ToLower(domain.Value), ".")
+		if !isHostname(value) {
+			return nil
+		}
+		if options.SuffixOnly {
+			return []geosite.Item{{
+				Type:  geosite.RuleTypeDomainSuffix,
+				Value: value,
+			}}
+		}
+		var items []geosite.Item
+		if strings.Contains(value, ".") {
+			items = append(items, geosite.Item{
+				Type:  geosite.RuleTypeDomain,
+				Value: value,
+			})
+		}
+		return append(items, geosite.Item{
+			Type:  geosite.RuleTypeDomainSuffix,
+			Value: "." + value,
+		})
+	case routercommon.Domain_Full:
+		value := strings.Trim(strings.ToLower(domain.Value), ".")
+		if !isHostname(value) {
+			return nil
+		}
+		return []geosite.Item{{
+			Type:  geosite.RuleTypeDomain,
+			Value: value,
+		}}
+	}
+	return nil
+}
+
+// isHostname reports whether value is a lowercased hostname of non-empty
+// labels, underscores are accepted as some upstream entries use them.
+func isHostname(value string) bool {
+	if len(value) > 253 {
+		return false
+	}
+	for _, label := range strings.Split(value, ".") {
+		if label == "" || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for _, char := range label {
+			if !(char >= 'a' && char <= 'z' || char >= '0' && char <= '9' || char == '-' || char == '_') {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func Compile(domains []geosite.Item) option.DefaultHeadlessRule {
+	var headlessRule option.DefaultHeadlessRule
+	defaultRule := geosite.Compile(domains)
+	headlessRule.Domain = defaultRule.Domain
+	headlessRule.DomainSuffix = defaultRule.DomainSuffix
+	headlessRule.DomainKeyword = defaultRule.DomainKeyword
+	headlessRule.DomainRegex = defaultRule.DomainRegex
+	return headlessRule
+}
+
+func RuleCount(headlessRule option.DefaultHeadlessRule) int {
+	return len(headlessRule.Domain) +
+		len(headlessRule.DomainSuffix) +
+		len(headlessRule.DomainKeyword) +
+		len(headlessRule.DomainRegex) +
+		len(headlessRule.IPCIDR)
+}
+
+// Union merges domainMaps code by code, the items of a code are deduplicated
+// and ordered by the first map they appear in.
+func Union(domainMaps []map[string][]geosite.Item) map[string][]geosite.Item {
+	if len(domainMaps) == 1 {
+		return domainMaps[0]
+	}
+	merged := make(map[string][]geosite.Item)
+	for _, domainMap := range domainMaps {
+		for code, domains := range domainMap {
+			merged[code] = append(merged[code], domains...)
+		}
+	}
+	for code, domains := range merged {
+		merged[code] = common.Uniq(domains)
+	}
+	return merged
+}
+
+// Merge returns the union of the items of codes in the order they first
+// appear.
+func Merge(domainMap map[string][]geosite.Item, codes []string) []geosite.Item {
+	seen := make(map[geosite.Item]struct{})
+	var merged []geosite.Item
+	for _, code := range codes {
+		for _, item := range domainMap[code] {
+			if _, loaded := seen[item]; loaded {
+				continue
+			}
+			seen[item] = struct{}{}
+			merged = append(merged, item)
+		}
+	}
+	return merged
+}
+
+// Complement returns the union of the items of every code of domainMap
+// except those carried by any of codes.
+func Complement(domainMap map[string][]geosite.Item, codes []string) []geosite.Item {
+	excluded := make(map[geosite.Item]struct{})
+	for _, item := range Merge(domainMap, codes) {
+		excluded[item] = struct{}{}
+	}
+	return common.Filter(Merge(domainMap, SortedCodes(domainMap)), func(it geosite.Item) bool {
+		_, loaded := excluded[it]
+		return !loaded
+	})
+}
@@ -0,0 +1,201 @@
+package geositegen
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/sagernet/sing-box/common/geosite"
+
+	"github.com/v2fly/v2ray-core/v5/app/router/routercommon"
+	"google.golang.org/protobuf/proto"
+)
+
+func parseFixture(t *testing.T, options ParseOptions, entries ...*routercommon.GeoSite) map[string][]geosite.Item {
+	t.Helper()
+	vGeositeData, err := proto.Marshal(&routercommon.GeoSiteList{Entry: entries})
+	if err != nil {
+		t.Fatal(err)
+	}
+	domainMap, err := Parse(vGeositeData, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return domainMap
+}
+
+func TestParseDomainTypes(t *testing.T) {
+	domainMap := parseFixture(t, ParseOptions{}, &routercommon.GeoSite{
+		CountryCode: "TEST",
+		Domain: []*routercommon.Domain{
+			{Type: routercommon.Domain_Plain, Value: "Keyword"},
+			{Type: routercommon.Domain_Regex, Value: `^Regex\.example\.com$`},
+			{Type: routercommon.Domain_RootDomain, Value: "Example.com"},
+			{Type: routercommon.Domain_RootDomain, Value: "localhost"},
+			{Type: routercommon.Domain_Full, Value: "full.example.org"},
+		},
+	})
+	expected := []geosite.Item{
+		{Type: geosite.RuleTypeDomainKeyword, Value: "keyword"},
+		{Type: geosite.RuleTypeDomainRegex, Value: `^Regex\.example\.com$`},
+		{Type: geosite.RuleTypeDomain, Value: "example.com"},
+		{Type: geosite.RuleTypeDomainSuffix, Value: ".example.com"},
+		{Type: geosite.RuleTypeDomainSuffix, Value: ".localhost"},
+		{Type: geosite.RuleTypeDomain, Value: "full.example.org"},
+	}
+	if !reflect.DeepEqual(domainMap["test"], expected) {
+		t.Fatalf("unexpected items: %v, expected %v", domainMap["test"], expected)
+	}
+}
+
+func TestParseSuffixOnly(t *testing.T) {
+	attribute := &routercommon.Domain_Attribute{
+		Key:        "cn",
+		TypedValue: &routercommon.Domain_Attribute_BoolValue{BoolValue: true},
+	}
+	domainMap := parseFixture(t, ParseOptions{SuffixOnly: true}, &routercommon.GeoSite{
+		CountryCode: "test",
+		Domain: []*routercommon.Domain{
+			{Type: routercommon.Domain_RootDomain, Value: "example.com", Attribute: []*routercommon.Domain_Attribute{attribute}},
+			{Type: routercommon.Domain_Full, Value: "full.example.org"},
+		},
+	})
+	expected := map[string][]geosite.Item{
+		"test": {
+			{Type: geosite.RuleTypeDomainSuffix, Value: "example.com"},
+			{Type: geosite.RuleTypeDomain, Value: "full.example.org"},
+		},
+		"test@cn": {
+			{Type: geosite.RuleTypeDomainSuffix, Value: "example.com"},
+		},
+	}
+	if !reflect.DeepEqual(domainMap, expected) {
+		t.Fatalf("unexpected domain map: %v, expected %v", domainMap, expected)
+	}
+}
+
+func TestParseNoRegex(t *testing.T) {
+	attribute := &routercommon.Domain_Attribute{
+		Key:        "ads",
+		TypedValue: &routercommon.Domain_Attribute_BoolValue{BoolValue: true},
+	}
+	domainMap := parseFixture(t, ParseOptions{NoRegex: true}, &routercommon.GeoSite{
+		CountryCode: "test",
+		Domain: []*routercommon.Domain{
+			{Type: routercommon.Domain_Regex, Value: `^ads\.`, Attribute: []*routercommon.Domain_Attribute{attribute}},
+			{Type: routercommon.Domain_Regex, Value: `^tracker\.`},
+			{Type: routercommon.Domain_Full, Value: "full.example.org"},
+		},
+	})
+	expected := map[string][]geosite.Item{
+		"test": {
+			{Type: geosite.RuleTypeDomain, Value: "full.example.org"},
+		},
+	}
+	if !reflect.DeepEqual(domainMap, expected) {
+		t.Fatalf("unexpected domain map: %v, expected %v", domainMap, expected)
+	}
+}
+
+func TestParseAttributes(t *testing.T) {
+	attribute := func(key string) *routercommon.Domain_Attribute {
+		return &routercommon.Domain_Attribute{
+			Key:        key,
+			TypedValue: &routercommon.Domain_Attribute_BoolValue{BoolValue: true},
+		}
+	}
+	domainMap := parseFixture(t, ParseOptions{}, &routercommon.GeoSite{
+		CountryCode: "test",
+		Domain: []*routercommon.Domain{
+			{Type: routercommon.Domain_Full, Value: "ads.example.com", Attribute: []*routercommon.Domain_Attribute{attribute("ads")}},
+			{Type: routercommon.Domain_Full, Value: "cn.example.com", Attribute: []*routercommon.Domain_Attribute{attribute("cn"), attribute("ads")}},
+			{Type: routercommon.Domain_Full, Value: "plain.example.com"},
+		},
+	})
+	expected := map[string][]geosite.Item{
+		"test": {
+			{Type: geosite.RuleTypeDomain, Value: "ads.example.com"},
+			{Type: geosite.RuleTypeDomain, Value: "cn.example.com"},
+			{Type: geosite.RuleTypeDomain, Value: "plain.example.com"},
+		},
+		"test@ads": {
+			{Type: geosite.RuleTypeDomain, Value: "ads.example.com"},
+			{Type: geosite.RuleTypeDomain, Value: "cn.example.com"},
+		},
+		"test@cn": {
+			{Type: geosite.RuleTypeDomain, Value: "cn.example.com"},
+		},
+	}
+	if !reflect.DeepEqual(domainMap, expected) {
+		t.Fatalf("unexpected domain map: %v, expected %v", domainMap, expected)
+	}
+}
+
+func TestParseDropsMalformed(t *testing.T) {
+	domainMap := parseFixture(t, ParseOptions{}, &routercommon.GeoSite{
+		CountryCode: "test",
+		Domain: []*routercommon.Domain{
+			{Type: routercommon.Domain_Full, Value: ""},
+			{Type: routercommon.Domain_Full, Value: "with space.example.com"},
+			{Type: routercommon.Domain_RootDomain, Value: "bad..example.com"},
+			{Type: routercommon.Domain_Regex, Value: "("},
+			{Type: routercommon.Domain_Full, Value: "ok.example.com"},
+		},
+	})
+	expected := []geosite.Item{
+		{Type: geosite.RuleTypeDomain, Value: "ok.example.com"},
+	}
+	if !reflect.DeepEqual(domainMap["test"], expected) {
+		t.Fatalf("unexpected items: %v, expected %v", domainMap["test"], expected)
+	}
+}
+
+func TestParseStrayDots(t *testing.T) {
+	for _, value := range []string{"example.com.", ".example.com", "..example.com"} {
+		items := ConvertDomain(&routercommon.Domain{Type: routercommon.Domain_RootDomain, Value: value}, ParseOptions{})
+		expected := []geosite.Item{
+			{Type: geosite.RuleTypeDomain, Value: "example.com"},
+			{Type: geosite.RuleTypeDomainSuffix, Value: ".example.com"},
+		}
+		if !reflect.DeepEqual(items, expected) {
+			t.Fatalf("unexpected root domain items of %s: %v, expected %v", value, items, expected)
+		}
+		items = ConvertDomain(&routercommon.Domain{Type: routercommon.Domain_Full, Value: value}, ParseOptions{})
+		expected = []geosite.Item{
+			{Type: geosite.RuleTypeDomain, Value: "example.com"},
+		}
+		if !reflect.DeepEqual(items, expected) {
+			t.Fatalf("unexpected full domain items of %s: %v, expected %v", value, items, expected)
+		}
+	}
+}
+
+func TestParseEmpty(t *testing.T) {
+	_, err := Parse(nil, ParseOptions{})
+	if err == nil {
+		t.Fatal("expected an error for a list without entries")
+	}
+}
+
+func TestParseDuplicates(t *testing.T) {
+	duplicates := make(map[string]int)
+	domainMap := parseFixture(t, ParseOptions{Duplicates: duplicates}, &routercommon.GeoSite{
+		CountryCode: "test",
+		Domain: []*routercommon.Domain{
+			{Type: routercommon.Domain_Full, Value: "example.com"},
+			{Type: routercommon.Domain_Full, Value: "Example.com"},
+			{Type: routercommon.Domain_RootDomain, Value: "example.com"},
+		},
+	}, &routercommon.GeoSite{
+		CountryCode: "unique",
+		Domain: []*routercommon.Domain{
+			{Type: routercommon.Domain_Full, Value: "example.org"},
+		},
+	})
+	if len(domainMap["test"]) != 2 {
+		t.Fatalf("unexpected items: %v", domainMap["test"])
+	}
+	expected := map[string]int{"test": 2}
+	if !reflect.DeepEqual(duplicates, expected) {
+		t.Fatalf("unexpected duplicates: %v, expected %v", duplicates, expected)
+	}
+}
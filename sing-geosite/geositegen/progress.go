@@ -0,0 +1,63 @@
+package geositegen
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// progressWriter counts the bytes written through it, typically from an
+// io.TeeReader, and redraws a one-line progress indicator on output. Writers
+// sharing output also share access, so that concurrent downloads do not
+// interleave their lines.
+type progressWriter struct {
+	output  io.Writer
+	access  *sync.Mutex
+	name    string
+	total   int64
+	current int64
+	drawn   time.Time
+}
+
+func newProgressWriter(output io.Writer, access *sync.Mutex, name string, total int64) *progressWriter {
+	return &progressWriter{
+		output: output,
+		access: access,
+		name:   name,
+		total:  total,
+	}
+}
+
+func (w *progressWriter) Write(p []byte) (int, error) {
+	w.current += int64(len(p))
+	if time.Since(w.drawn) >= 100*time.Millisecond {
+		w.access.Lock()
+		w.draw()
+		w.access.Unlock()
+	}
+	return len(p), nil
+}
+
+// draw redraws the line, access must be held.
+func (w *progressWriter) draw() {
+	w.drawn = time.Now()
+	if w.total > 0 {
+		fmt.Fprintf(w.output, "\r%s %3d%% %.1f/%.1f MiB", w.name, w.current*100/w.total, mebibytes(w.current), mebibytes(w.total))
+	} else {
+		fmt.Fprintf(w.output, "\r%s %.1f MiB", w.name, mebibytes(w.current))
+	}
+}
+
+// Close draws the final state and ends the line.
+func (w *progressWriter) Close() error {
+	w.access.Lock()
+	defer w.access.Unlock()
+	w.draw()
+	_, err := io.WriteString(w.output, "\n")
+	return err
+}
+
+func mebibytes(n int64) float64 {
+	return float64(n) / (1 << 20)
+}
@@ -0,0 +1,54 @@
+package geositegen
+
+import (
+	"path"
+	"strings"
+)
+
+// ShardKey maps a code to the name of the shard it is written to.
+type ShardKey func(code string) string
+
+// LetterShardKey shards codes by their first character.
+func LetterShardKey(code string) string {
+	if code == "" {
+		return "other"
+	}
+	return code[:1]
+}
+
+// PrefixShardKey shards codes by the part before the first dash, so that
+// category-ads and category-games share the category shard.
+func PrefixShardKey(code string) string {
+	prefix, _, _ := strings.Cut(strings.SplitN(code, "@", 2)[0], "-")
+	return prefix
+}
+
+// GroupShardKey shards codes into the first group, by name, with a pattern
+// matching the code, codes matching no group go to the other shard.
+func GroupShardKey(groups map[string][]string) ShardKey {
+	names := SortedCodes(groups)
+	return func(code string) string {
+		for _, name := range names {
+			for _, pattern := range groups[name] {
+				matched, _ := path.Match(pattern, code)
+				if matched {
+					return name
+				}
+			}
+		}
+		return "other"
+	}
+}
+
+// Shard splits codeMap into one map per shard key.
+func Shard[T any](codeMap map[string]T, key ShardKey) map[string]map[string]T {
+	shards := make(map[string]map[string]T)
+	for code, value := range codeMap {
+		shardName := key(code)
+		if shards[shardName] == nil {
+			shards[shardName] = make(map[string]T)
+		}
+		shards[shardName][code] = value
+	}
+	return shards
+}
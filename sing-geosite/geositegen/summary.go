@@ -0,0 +1,52 @@
+package geositegen
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// SummaryTopCodes is the number of largest codes listed by Summary.
+const SummaryTopCodes = 10
+
+// Summary returns a markdown summary of a run producing current from
+// sourceTag, meant as a pull request body. The added and removed codes are
+// counted against the previous manifest unless it is nil.
+func Summary(sourceTag string, previous *Manifest, current []ManifestEntry) string {
+	var totalRules int
+	for _, entry := range current {
+		totalRules += entry.RuleCount
+	}
+	var builder strings.Builder
+	builder.WriteString("## Summary\n\n")
+	builder.WriteString("- Source: `" + sourceTag + "`\n")
+	builder.WriteString("- Codes: " + strconv.Itoa(len(current)) + "\n")
+	builder.WriteString("- Total rules: " + strconv.Itoa(totalRules) + "\n")
+	if previous != nil {
+		changes := Diff(previous.RuleSets, current)
+		builder.WriteString("- Added codes: " + strconv.Itoa(len(changes.Added)) + "\n")
+		builder.WriteString("- Removed codes: " + strconv.Itoa(len(changes.Removed)) + "\n")
+		builder.WriteString("- Changed codes: " + strconv.Itoa(len(changes.Changed)) + "\n")
+	} else {
+		builder.WriteString("- No previous run to compare against\n")
+	}
+	largest := append([]ManifestEntry(nil), current...)
+	sort.Slice(largest, func(i, j int) bool {
+		if largest[i].RuleCount != largest[j].RuleCount {
+			return largest[i].RuleCount > largest[j].RuleCount
+		}
+		return largest[i].Code < largest[j].Code
+	})
+	if len(largest) > SummaryTopCodes {
+		largest = largest[:SummaryTopCodes]
+	}
+	if len(largest) > 0 {
+		builder.WriteString("\n### Largest codes\n\n")
+		builder.WriteString("| Code | Rules |\n")
+		builder.WriteString("| --- | ---: |\n")
+		for _, entry := range largest {
+			builder.WriteString("| `" + entry.Code + "` | " + strconv.Itoa(entry.RuleCount) + " |\n")
+		}
+	}
+	return builder.String()
+}
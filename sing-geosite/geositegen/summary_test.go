@@ -0,0 +1,43 @@
+package geositegen
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSummary(t *testing.T) {
+	current := []ManifestEntry{
+		{Code: "cn", RuleCount: 3, ContentSHA256: "1"},
+		{Code: "google", RuleCount: 5, ContentSHA256: "2"},
+		{Code: "new", RuleCount: 1, ContentSHA256: "3"},
+	}
+	summary := Summary("v2fly/domain-list-community@20240101", nil, current)
+	for _, line := range []string{
+		"- Source: `v2fly/domain-list-community@20240101`\n",
+		"- Codes: 3\n",
+		"- Total rules: 9\n",
+		"- No previous run to compare against\n",
+		"| `google` | 5 |\n| `cn` | 3 |\n| `new` | 1 |\n",
+	} {
+		if !strings.Contains(summary, line) {
+			t.Errorf("summary without previous misses %q:\n%s", line, summary)
+		}
+	}
+	summary = Summary("v2fly/domain-list-community@20240101", &Manifest{RuleSets: []ManifestEntry{
+		{Code: "cn", RuleCount: 3, ContentSHA256: "1"},
+		{Code: "google", RuleCount: 4, ContentSHA256: "4"},
+		{Code: "old", RuleCount: 2, ContentSHA256: "5"},
+	}}, current)
+	for _, line := range []string{
+		"- Added codes: 1\n",
+		"- Removed codes: 1\n",
+		"- Changed codes: 1\n",
+	} {
+		if !strings.Contains(summary, line) {
+			t.Errorf("summary with previous misses %q:\n%s", line, summary)
+		}
+	}
+	if strings.Contains(summary, "No previous run") {
+		t.Errorf("summary with previous reports no previous run:\n%s", summary)
+	}
+}
@@ -0,0 +1,36 @@
+package geositegen
+
+import (
+	"bytes"
+	"strings"
+
+	"github.com/sagernet/sing-box/option"
+)
+
+// TextList formats the domain items of headlessRule one per line with the
+// full:, domain:, keyword: and regexp: prefixes of domain-list-community.
+// An exact domain also covered by the suffix of the same domain is written as
+// domain: alone.
+func TextList(headlessRule option.DefaultHeadlessRule) []byte {
+	suffixes := make(map[string]bool, len(headlessRule.DomainSuffix))
+	for _, suffix := range headlessRule.DomainSuffix {
+		suffixes[strings.TrimPrefix(suffix, ".")] = true
+	}
+	var buffer bytes.Buffer
+	for _, domain := range headlessRule.Domain {
+		if suffixes[domain] {
+			continue
+		}
+		buffer.WriteString("full:" + domain + "\n")
+	}
+	for _, suffix := range headlessRule.DomainSuffix {
+		buffer.WriteString("domain:" + strings.TrimPrefix(suffix, ".") + "\n")
+	}
+	for _, keyword := range headlessRule.DomainKeyword {
+		buffer.WriteString("keyword:" + keyword + "\n")
+	}
+	for _, regex := range headlessRule.DomainRegex {
+		buffer.WriteString("regexp:" + regex + "\n")
+	}
+	return buffer.Bytes()
+}
@@ -0,0 +1,588 @@
+package geositegen
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sagernet/sing-box/common/srs"
+	C "github.com/sagernet/sing-box/constant"
+	"github.com/sagernet/sing-box/option"
+	E "github.com/sagernet/sing/common/exceptions"
+)
+
+type RuleSetWriter struct {
+	// Gzip also writes a gzip-compressed copy of each .srs file.
+	Gzip bool
+	// SkipSRS and SkipJSON leave out the binary and the source form of each
+	// rule set, the manifest describes the .json files if .srs is skipped.
+	SkipSRS  bool
+	SkipJSON bool
+	// Verify decodes each .json rule set again and fails if encoding the
+	// result does not give the same content.
+	Verify bool
+	// VerifyOutput reads each .srs file back from disk and fails, removing
+	// the file, if it does not decode to the items it was written with. The
+	// format keeps domains and suffixes as a matcher, so each of them is
+	// checked to match, and merges IP CIDR items, so they are only decoded.
+	VerifyOutput bool
+	// Optimize drops the domain suffixes covered by a shorter suffix of the
+	// same rule set.
+	Optimize bool
+	// Sort orders the domain items of each rule set so that the output does
+	// not depend on the upstream order.
+	Sort bool
+	// AttributeSeparator replaces the @ of code@attribute in file names,
+	// the @ is kept if empty.
+	AttributeSeparator string
+	// Text also writes each rule set as a plain-text name.txt list, see
+	// TextList.
+	Text bool
+	// Version is written to a name.version file next to each rule set,
+	// as the rule set format has no field for it, disabled if empty.
+	Version string
+	// PruneEmpty skips the codes of WriteRuleSets whose rule set has no
+	// items, leaving them out of the returned manifest entries.
+	PruneEmpty bool
+	// JSONIndent indents the .json rule sets, they are minified if empty.
+	JSONIndent string
+	// Nested places the rule sets of each code in a subdirectory named after
+	// the code up to its first - or @.
+	Nested bool
+	// ModTime is set as the modification time of the files written, files
+	// skipped as unchanged keep theirs. Disabled if zero.
+	ModTime time.Time
+	// Previous holds the manifest entries of the last run by code, the rule
+	// sets of WriteRuleSets built from the same content whose files are all
+	// present are kept as they are instead of being encoded and written again.
+	Previous map[string]ManifestEntry
+
+	output  string
+	force   bool
+	access  sync.Mutex
+	written map[string]bool
+	changed int
+	pruned  int
+	empty   []string
+	reused  int
+}
+
+func NewRuleSetWriter(output string, force bool) (*RuleSetWriter, error) {
+	if force {
+		os.RemoveAll(output)
+	}
+	err := os.MkdirAll(output, 0o755)
+	if err != nil {
+		return nil, err
+	}
+	return &RuleSetWriter{
+		JSONIndent: "    ",
+		output:     output,
+		force:      force,
+		written:    make(map[string]bool),
+	}, nil
+}
+
+func (w *RuleSetWriter) WriteFile(name string, content []byte) error {
+	_, err := w.writeFile(name, content)
+	return err
+}
+
+// writeFile is WriteFile also reporting whether the file was written.
+func (w *RuleSetWriter) writeFile(name string, content []byte) (bool, error) {
+	w.access.Lock()
+	w.written[name] = true
+	w.access.Unlock()
+	path, _ := filepath.Abs(filepath.Join(w.output, filepath.FromSlash(name)))
+	if !w.force {
+		existing, err := os.ReadFile(path)
+		if err == nil && sha256.Sum256(existing) == sha256.Sum256(content) {
+			return false, nil
+		}
+	}
+	w.access.Lock()
+	w.changed++
+	w.access.Unlock()
+	if strings.Contains(name, "/") {
+		err := os.MkdirAll(filepath.Dir(path), 0o755)
+		if err != nil {
+			return false, err
+		}
+	}
+	LogFile("write", path)
+	err := WriteFileAtomic(path, content)
+	if err != nil {
+		return false, err
+	}
+	return true, w.setModTime(path)
+}
+
+func (w *RuleSetWriter) setModTime(path string) error {
+	if w.ModTime.IsZero() {
+		return nil
+	}
+	return os.Chtimes(path, w.ModTime, w.ModTime)
+}
+
+// WrittenFiles returns the number of files actually written, those skipped
+// as unchanged excluded.
+func (w *RuleSetWriter) WrittenFiles() int {
+	w.access.Lock()
+	defer w.access.Unlock()
+	return w.changed
+}
+
+// PrunedSuffixes returns the number of domain suffixes dropped by Optimize.
+func (w *RuleSetWriter) PrunedSuffixes() int {
+	w.access.Lock()
+	defer w.access.Unlock()
+	return w.pruned
+}
+
+// ReusedRuleSets returns the number of rule sets kept from Previous.
+func (w *RuleSetWriter) ReusedRuleSets() int {
+	w.access.Lock()
+	defer w.access.Unlock()
+	return w.reused
+}
+
+// EmptyCodes returns the sorted codes skipped by PruneEmpty.
+func (w *RuleSetWriter) EmptyCodes() []string {
+	w.access.Lock()
+	defer w.access.Unlock()
+	codes := append([]string(nil), w.empty...)
+	sort.Strings(codes)
+	return codes
+}
+
+// Prepare returns headlessRule as WriteRuleSet encodes it, with Optimize and
+// Sort applied.
+func (w *RuleSetWriter) Prepare(headlessRule option.DefaultHeadlessRule) option.DefaultHeadlessRule {
+	headlessRule, _ = w.prepare(headlessRule)
+	return headlessRule
+}
+
+// prepare is Prepare also returning the number of suffixes pruned.
+func (w *RuleSetWriter) prepare(headlessRule option.DefaultHeadlessRule) (option.DefaultHeadlessRule, int) {
+	var pruned int
+	if w.Optimize {
+		headlessRule.DomainSuffix, pruned = OptimizeSuffixes(headlessRule.DomainSuffix)
+	}
+	if w.Sort {
+		SortRule(&headlessRule)
+	}
+	return headlessRule, pruned
+}
+
+func (w *RuleSetWriter) WriteRuleSet(name string, headlessRule option.DefaultHeadlessRule) (ManifestEntry, error) {
+	headlessRule, pruned := w.prepare(headlessRule)
+	if pruned > 0 {
+		w.access.Lock()
+		w.pruned += pruned
+		w.access.Unlock()
+	}
+	manifestEntry := ManifestEntry{
+		RuleCount: RuleCount(headlessRule),
+	}
+	var buffer bytes.Buffer
+	if !w.SkipSRS {
+		err := WriteSRS(&buffer, headlessRule)
+		if err != nil {
+			return ManifestEntry{}, E.Cause(err, "encode ", name, ".srs")
+		}
+		manifestEntry.setFile(name+".srs", buffer.Bytes())
+		written, err := w.writeFile(name+".srs", buffer.Bytes())
+		if err != nil {
+			return ManifestEntry{}, E.Cause(err, "write ", name, ".srs")
+		}
+		if w.VerifyOutput {
+			err = w.verifyOutput(name+".srs", headlessRule)
+			if err != nil {
+				return ManifestEntry{}, E.Cause(err, "verify ", name, ".srs")
+			}
+		}
+		if w.Gzip {
+			err = w.writeGzip(name+".srs", written)
+			if err != nil {
+				return ManifestEntry{}, E.Cause(err, "write ", name, ".srs.gz")
+			}
+		}
+	}
+	if !w.SkipJSON {
+		buffer.Reset()
+		err := WriteJSON(&buffer, headlessRule, w.JSONIndent)
+		if err != nil {
+			return ManifestEntry{}, E.Cause(err, "encode ", name, ".json")
+		}
+		if w.Verify {
+			err = verifyJSON(buffer.Bytes(), w.JSONIndent)
+			if err != nil {
+				return ManifestEntry{}, E.Cause(err, "verify ", name, ".json")
+			}
+		}
+		if w.SkipSRS {
+			manifestEntry.setFile(name+".json", buffer.Bytes())
+		}
+		err = w.WriteFile(name+".json", buffer.Bytes())
+		if err != nil {
+			return ManifestEntry{}, E.Cause(err, "write ", name, ".json")
+		}
+	}
+	if w.Text {
+		err := w.WriteFile(name+".txt", TextList(headlessRule))
+		if err != nil {
+			return ManifestEntry{}, E.Cause(err, "write ", name, ".txt")
+		}
+	}
+	if w.Version != "" {
+		err := w.WriteFile(name+".version", []byte(w.Version+"\n"))
+		if err != nil {
+			return ManifestEntry{}, E.Cause(err, "write ", name, ".version")
+		}
+	}
+	return manifestEntry, nil
+}
+
+func verifyJSON(content []byte, indent string) error {
+	var plainRuleSet option.PlainRuleSet
+	err := json.Unmarshal(content, &plainRuleSet)
+	if err != nil {
+		return err
+	}
+	var buffer bytes.Buffer
+	err = encodeJSON(&buffer, plainRuleSet, indent)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(buffer.Bytes(), content) {
+		return E.New("content does not round-trip through option.PlainRuleSet")
+	}
+	return nil
+}
+
+func (w *RuleSetWriter) verifyOutput(name string, headlessRule option.DefaultHeadlessRule) error {
+	path, _ := filepath.Abs(filepath.Join(w.output, filepath.FromSlash(name)))
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	plainRuleSet, err := srs.Read(file, true)
+	file.Close()
+	if err == nil {
+		err = verifyDecoded(plainRuleSet, headlessRule)
+	}
+	if err != nil {
+		LogFile("remove", path)
+		os.Remove(path)
+		return err
+	}
+	return nil
+}
+
+// verifyDecoded checks that plainRuleSet, as decoded by srs.Read with
+// recovery, holds headlessRule as its only rule.
+func verifyDecoded(plainRuleSet option.PlainRuleSet, headlessRule option.DefaultHeadlessRule) error {
+	if len(plainRuleSet.Rules) != 1 || plainRuleSet.Rules[0].Type != C.RuleTypeDefault {
+		return E.New("decoded ", len(plainRuleSet.Rules), " rules, expected a single default rule")
+	}
+	decoded := plainRuleSet.Rules[0].DefaultOptions
+	if len(headlessRule.Domain) > 0 || len(headlessRule.DomainSuffix) > 0 {
+		if decoded.DomainMatcher == nil {
+			return E.New("decoded no domain items")
+		}
+		for _, domain := range headlessRule.Domain {
+			if !decoded.DomainMatcher.Match(domain) {
+				return E.New("decoded domains do not match ", domain)
+			}
+		}
+		for _, suffix := range headlessRule.DomainSuffix {
+			// A suffix with a leading dot only matches subdomains.
+			if !decoded.DomainMatcher.Match("sub." + strings.TrimPrefix(suffix, ".")) {
+				return E.New("decoded domain suffixes do not match ", suffix)
+			}
+		}
+	} else if decoded.DomainMatcher != nil {
+		return E.New("decoded unexpected domain items")
+	}
+	if !equalItems(decoded.DomainKeyword, headlessRule.DomainKeyword) {
+		return E.New("decoded ", len(decoded.DomainKeyword), " domain keywords, expected ", len(headlessRule.DomainKeyword))
+	}
+	if !equalItems(decoded.DomainRegex, headlessRule.DomainRegex) {
+		return E.New("decoded ", len(decoded.DomainRegex), " domain regexes, expected ", len(headlessRule.DomainRegex))
+	}
+	if (len(decoded.IPCIDR) == 0) != (len(headlessRule.IPCIDR) == 0) {
+		return E.New("decoded ", len(decoded.IPCIDR), " IP CIDR items, expected ", len(headlessRule.IPCIDR), " before merging")
+	}
+	return nil
+}
+
+func equalItems(decoded []string, expected []string) bool {
+	if len(decoded) != len(expected) {
+		return false
+	}
+	for i := range decoded {
+		if decoded[i] != expected[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// SortRule sorts the domain items of headlessRule in place.
+func SortRule(headlessRule *option.DefaultHeadlessRule) {
+	sort.Strings(headlessRule.Domain)
+	sort.Strings(headlessRule.DomainSuffix)
+	sort.Strings(headlessRule.DomainKeyword)
+	sort.Strings(headlessRule.DomainRegex)
+}
+
+// writeGzip compresses the already written file name into name.gz, the copy is
+// left alone if the file was not written again and is not newer than the
+// copy, unless force is set.
+func (w *RuleSetWriter) writeGzip(name string, written bool) error {
+	w.access.Lock()
+	w.written[name+".gz"] = true
+	w.access.Unlock()
+	sourcePath, _ := filepath.Abs(filepath.Join(w.output, filepath.FromSlash(name)))
+	path := sourcePath + ".gz"
+	sourceInfo, err := os.Stat(sourcePath)
+	if err != nil {
+		return err
+	}
+	if !w.force && !written {
+		info, err := os.Stat(path)
+		if err == nil && !info.ModTime().Before(sourceInfo.ModTime()) {
+			return nil
+		}
+	}
+	sourceFile, err := os.Open(sourcePath)
+	if err != nil {
+		return err
+	}
+	defer sourceFile.Close()
+	w.access.Lock()
+	w.changed++
+	w.access.Unlock()
+	LogFile("write", path)
+	file, err := CreateAtomic(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	gzipWriter, err := gzip.NewWriterLevel(file, gzip.BestCompression)
+	if err != nil {
+		return err
+	}
+	// ModTime is left zero so that the copy only depends on the content.
+	gzipWriter.Name = filepath.Base(sourcePath)
+	_, err = io.Copy(gzipWriter, sourceFile)
+	if err != nil {
+		return err
+	}
+	err = gzipWriter.Close()
+	if err != nil {
+		return err
+	}
+	err = file.Commit()
+	if err != nil {
+		return err
+	}
+	return w.setModTime(path)
+}
+
+// FileCode returns code as used in file names.
+func (w *RuleSetWriter) FileCode(code string) string {
+	if w.AttributeSeparator == "" {
+		return code
+	}
+	return strings.ReplaceAll(code, "@", w.AttributeSeparator)
+}
+
+// RuleSetName returns the name of the rule set of code without extension,
+// relative to the output directory and slash separated.
+func (w *RuleSetWriter) RuleSetName(prefix string, code string) string {
+	name := prefix + w.FileCode(code)
+	if !w.Nested {
+		return name
+	}
+	directory, _, _ := strings.Cut(code, "@")
+	directory, _, _ = strings.Cut(directory, "-")
+	return directory + "/" + name
+}
+
+// WriteRuleSets writes the rule set built for each code as prefix-code on at
+// most concurrency goroutines.
+func (w *RuleSetWriter) WriteRuleSets(prefix string, codes []string, concurrency int, build func(code string) option.DefaultHeadlessRule) ([]ManifestEntry, error) {
+	names := make(map[string]string, len(codes))
+	for _, code := range codes {
+		name := w.RuleSetName(prefix, code)
+		if existing, loaded := names[name]; loaded {
+			return nil, E.New("codes ", existing, " and ", code, " are both written as ", name, ", change the attribute separator")
+		}
+		names[name] = code
+	}
+	manifestEntries := make([]ManifestEntry, len(codes))
+	written := make([]bool, len(codes))
+	err := parallel(concurrency, len(codes), func(index int) error {
+		code := codes[index]
+		headlessRule := build(code)
+		if w.PruneEmpty && RuleCount(headlessRule) == 0 {
+			w.access.Lock()
+			w.empty = append(w.empty, code)
+			w.access.Unlock()
+			return nil
+		}
+		name := w.RuleSetName(prefix, code)
+		contentHash := w.contentHash(headlessRule)
+		if previous, loaded := w.Previous[code]; loaded && w.reuse(name, previous, contentHash) {
+			manifestEntries[index] = previous
+			written[index] = true
+			return nil
+		}
+		manifestEntry, err := w.WriteRuleSet(name, headlessRule)
+		if err != nil {
+			return err
+		}
+		manifestEntry.Code = code
+		manifestEntry.ContentSHA256 = contentHash
+		manifestEntries[index] = manifestEntry
+		written[index] = true
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	writtenEntries := manifestEntries[:0]
+	for index, manifestEntry := range manifestEntries {
+		if written[index] {
+			writtenEntries = append(writtenEntries, manifestEntry)
+		}
+	}
+	return writtenEntries, nil
+}
+
+// contentHash returns the hex SHA-256 of the items of headlessRule and of the
+// settings affecting the files written for it.
+func (w *RuleSetWriter) contentHash(headlessRule option.DefaultHeadlessRule) string {
+	hash := sha256.New()
+	fmt.Fprintln(hash, w.Gzip, w.SkipSRS, w.SkipJSON, w.Optimize, w.Sort, w.Text, strconv.Quote(w.JSONIndent), strconv.Quote(w.Version))
+	for _, items := range [][]string{
+		headlessRule.Domain,
+		headlessRule.DomainSuffix,
+		headlessRule.DomainKeyword,
+		headlessRule.DomainRegex,
+		headlessRule.IPCIDR,
+	} {
+		for _, item := range items {
+			io.WriteString(hash, item)
+			hash.Write([]byte{0})
+		}
+		hash.Write([]byte{'\n'})
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// ruleSetFiles returns the files WriteRuleSet writes for name, the one
+// described by its manifest entry first.
+func (w *RuleSetWriter) ruleSetFiles(name string) []string {
+	var files []string
+	if !w.SkipSRS {
+		files = append(files, name+".srs")
+		if w.Gzip {
+			files = append(files, name+".srs.gz")
+		}
+	}
+	if !w.SkipJSON {
+		files = append(files, name+".json")
+	}
+	if w.Text {
+		files = append(files, name+".txt")
+	}
+	if w.Version != "" {
+		files = append(files, name+".version")
+	}
+	return files
+}
+
+// reuse reports whether the rule set name built from contentHash can be kept
+// as described by previous, marking its files as written if so.
+func (w *RuleSetWriter) reuse(name string, previous ManifestEntry, contentHash string) bool {
+	files := w.ruleSetFiles(name)
+	if previous.ContentSHA256 != contentHash || len(files) == 0 || previous.Path != files[0] {
+		return false
+	}
+	for _, file := range files {
+		if _, err := os.Stat(filepath.Join(w.output, filepath.FromSlash(file))); err != nil {
+			return false
+		}
+	}
+	w.access.Lock()
+	defer w.access.Unlock()
+	for _, file := range files {
+		w.written[file] = true
+	}
+	w.reused++
+	return true
+}
+
+// Close removes files left in the output directory by previous runs which
+// were not written again, subdirectories are only cleaned if Nested is set
+// and those left empty are removed. Hidden subdirectories are kept.
+func (w *RuleSetWriter) Close() error {
+	return w.clean("")
+}
+
+func (w *RuleSetWriter) clean(directory string) error {
+	entries, err := os.ReadDir(filepath.Join(w.output, filepath.FromSlash(directory)))
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		name := path.Join(directory, entry.Name())
+		if entry.IsDir() {
+			if !w.Nested || strings.HasPrefix(entry.Name(), ".") {
+				continue
+			}
+			err = w.clean(name)
+			if err != nil {
+				return err
+			}
+			entryPath := filepath.Join(w.output, filepath.FromSlash(name))
+			if subEntries, err := os.ReadDir(entryPath); err == nil && len(subEntries) == 0 {
+				os.Remove(entryPath)
+			}
+			continue
+		}
+		if w.written[name] {
+			continue
+		}
+		filePath, _ := filepath.Abs(filepath.Join(w.output, filepath.FromSlash(name)))
+		LogFile("remove", filePath)
+		err = os.Remove(filePath)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func SortedCodes[T any](codeMap map[string]T) []string {
+	codes := make([]string, 0, len(codeMap))
+	for code := range codeMap {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+	return codes
+}
@@ -0,0 +1,48 @@
+package geositegen
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sagernet/sing-box/option"
+)
+
+func TestWriteRuleSetVerifyOutput(t *testing.T) {
+	output := t.TempDir()
+	ruleSetWriter, err := NewRuleSetWriter(output, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ruleSetWriter.VerifyOutput = true
+	_, err = ruleSetWriter.WriteRuleSet("geosite-test", option.DefaultHeadlessRule{
+		Domain:        []string{"example.com", "full.example.org"},
+		DomainSuffix:  []string{".example.com", "example.net"},
+		DomainKeyword: []string{"keyword"},
+		DomainRegex:   []string{`^regex\.example\.com$`},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = os.Stat(filepath.Join(output, "geosite-test.srs"))
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPrepare(t *testing.T) {
+	ruleSetWriter, err := NewRuleSetWriter(t.TempDir(), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ruleSetWriter.Optimize = true
+	headlessRule := ruleSetWriter.Prepare(option.DefaultHeadlessRule{
+		DomainSuffix: []string{".example.com", ".www.example.com"},
+	})
+	if len(headlessRule.DomainSuffix) != 1 {
+		t.Fatalf("unexpected suffixes: %v, expected [.example.com]", headlessRule.DomainSuffix)
+	}
+	if pruned := ruleSetWriter.PrunedSuffixes(); pruned != 0 {
+		t.Fatalf("Prepare counted %d pruned suffixes, expected none", pruned)
+	}
+}
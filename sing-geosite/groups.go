@@ -0,0 +1,50 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+
+	"github.com/sagernet/sing-box/common/geosite"
+	E "github.com/sagernet/sing/common/exceptions"
+
+	"sing-geosite/geositegen"
+)
+
+// readGroups reads a json object mapping group names to their member codes.
+func readGroups(path string) (map[string][]string, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, E.Cause(err, "read groups")
+	}
+	var groups map[string][]string
+	err = json.Unmarshal(content, &groups)
+	if err != nil {
+		return nil, E.Cause(err, "decode groups ", path)
+	}
+	for name := range groups {
+		if name == "" {
+			return nil, E.New("decode groups ", path, ": empty group name")
+		}
+	}
+	return groups, nil
+}
+
+// addGroups adds each group to domainMap as a code holding the union of its
+// members, missing members are skipped with a warning.
+func addGroups(domainMap map[string][]geosite.Item, groups map[string][]string) {
+	for _, name := range geositegen.SortedCodes(groups) {
+		if _, loaded := domainMap[name]; loaded {
+			geositegen.Warn("group shadows an upstream code, skipped", geositegen.F("group", name))
+			continue
+		}
+		var members []string
+		for _, member := range groups[name] {
+			if _, loaded := domainMap[member]; !loaded {
+				geositegen.Warn("missing group member, skipped", geositegen.F("group", name), geositegen.F("code", member))
+				continue
+			}
+			members = append(members, member)
+		}
+		domainMap[name] = geositegen.Merge(domainMap, members)
+	}
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"os"
+	"sort"
+	"strconv"
+	"text/tabwriter"
+
+	"github.com/sagernet/sing-box/common/geosite"
+	E "github.com/sagernet/sing/common/exceptions"
+)
+
+// list prints the codes of a geosite database with their item counts.
+func list(path string) error {
+	reader, codes, err := geosite.Open(path)
+	if err != nil {
+		return E.Cause(err, "open ", path)
+	}
+	sort.Strings(codes)
+	writer := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	writer.Write([]byte("code\titems\n"))
+	for _, code := range codes {
+		items, err := reader.Read(code)
+		if err != nil {
+			return E.Cause(err, "read ", code, " from ", path)
+		}
+		writer.Write([]byte(code + "\t" + strconv.Itoa(len(items)) + "\n"))
+	}
+	return writer.Flush()
+}
@@ -2,299 +2,1048 @@ package main
 
 import (
 	"context"
-	"crypto/sha256"
+	"crypto/rand"
 	"encoding/hex"
-	"encoding/json"
-	"io"
+	"errors"
 	"net/http"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"regexp"
+	"sort"
 	"strings"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/sagernet/sing-box/common/geosite"
-	"github.com/sagernet/sing-box/common/srs"
-	C "github.com/sagernet/sing-box/constant"
-	"github.com/sagernet/sing-box/log"
 	"github.com/sagernet/sing-box/option"
 	"github.com/sagernet/sing/common"
 	E "github.com/sagernet/sing/common/exceptions"
 
 	"github.com/google/go-github/v45/github"
 	"github.com/v2fly/v2ray-core/v5/app/router/routercommon"
-	"google.golang.org/protobuf/proto"
+	"sing-geosite/geositegen"
 )
 
-var githubClient *github.Client
+var (
+	githubClient        *github.Client
+	githubAuthenticated bool
+	logRateLimitOnce    sync.Once
+)
 
-func init() {
-	accessToken, loaded := os.LookupEnv("ACCESS_TOKEN")
-	if !loaded {
-		githubClient = github.NewClient(nil)
-		return
+// publicGitHubAPIURL is the API of github.com, which Actions also sets as
+// GITHUB_API_URL.
+const publicGitHubAPIURL = "https://api.github.com"
+
+// setupGitHubClient creates the client over httpClient authenticated by
+// ACCESS_TOKEN, against the GitHub Enterprise API at apiURL unless it is empty
+// or the public API. Assets are downloaded from the URLs returned by that API,
+// so they follow the same host.
+func setupGitHubClient(apiURL string, httpClient *http.Client) error {
+	accessToken := os.Getenv("ACCESS_TOKEN")
+	if accessToken != "" {
+		authenticatedClient := *httpClient
+		authenticatedClient.Transport = &tokenTransport{accessToken, httpClient.Transport}
+		httpClient = &authenticatedClient
+		githubAuthenticated = true
+	}
+	if apiURL == "" || strings.TrimSuffix(apiURL, "/") == publicGitHubAPIURL {
+		githubClient = github.NewClient(httpClient)
+		return nil
+	}
+	enterpriseClient, err := github.NewEnterpriseClient(apiURL, enterpriseUploadURL(apiURL), httpClient)
+	if err != nil {
+		return E.Cause(err, "create github client for ", apiURL)
+	}
+	githubClient = enterpriseClient
+	return nil
+}
+
+// enterpriseUploadURL returns the upload API of the GitHub Enterprise server
+// of apiURL, taken from GITHUB_SERVER_URL when apiURL is the GITHUB_API_URL of
+// Actions, the server of a /api/v3 API otherwise.
+func enterpriseUploadURL(apiURL string) string {
+	serverURL := strings.TrimSuffix(strings.TrimSuffix(apiURL, "/"), "/api/v3")
+	if apiURL == os.Getenv("GITHUB_API_URL") && os.Getenv("GITHUB_SERVER_URL") != "" {
+		serverURL = os.Getenv("GITHUB_SERVER_URL")
+	}
+	return strings.TrimSuffix(serverURL, "/") + "/api/uploads/"
+}
+
+// tokenTransport authenticates requests with a bearer token, BasicAuthTransport
+// with an empty password is not honored as token authentication. Requests go
+// through base, http.DefaultTransport if nil.
+type tokenTransport struct {
+	token string
+	base  http.RoundTripper
+}
+
+func (t *tokenTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	request = request.Clone(request.Context())
+	request.Header.Set("Authorization", "Bearer "+t.token)
+	base := t.base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return base.RoundTrip(request)
+}
+
+// latestSelection picks the latest release of a repository, the one GitHub
+// reports as latest unless list is set.
+type latestSelection struct {
+	// list takes the newest non-draft release of the release list.
+	list bool
+	// prerelease accepts prereleases when list is set.
+	prerelease bool
+}
+
+// fetch returns the release of from tagged tag, the latest by latest if
+// empty. Rate limit errors carry their reset time, and are waited out if
+// waitRateLimit.
+func fetch(ctx context.Context, from string, tag string, latest latestSelection, waitRateLimit bool) (*github.RepositoryRelease, error) {
+	for {
+		release, err := fetchOnce(ctx, from, tag, latest)
+		if err == nil {
+			return release, nil
+		}
+		reset, limited := rateLimitReset(err)
+		if !limited {
+			return nil, err
+		}
+		geositegen.Warn("github api rate limited", geositegen.F("repository", from), geositegen.F("reset", reset.Format(time.RFC3339)), geositegen.F("authenticated", githubAuthenticated))
+		if !waitRateLimit {
+			return nil, E.Cause(err, "rate limited until ", reset.Format(time.RFC3339))
+		}
+		select {
+		case <-time.After(time.Until(reset)):
+		case <-ctx.Done():
+			return nil, E.Cause(ctx.Err(), "wait for rate limit reset")
+		}
+	}
+}
+
+func rateLimitReset(err error) (time.Time, bool) {
+	var rateLimitError *github.RateLimitError
+	if errors.As(err, &rateLimitError) {
+		return rateLimitError.Rate.Reset.Time, true
 	}
-	transport := &github.BasicAuthTransport{
-		Username: accessToken,
+	var abuseRateLimitError *github.AbuseRateLimitError
+	if errors.As(err, &abuseRateLimitError) {
+		retryAfter := time.Minute
+		if abuseRateLimitError.RetryAfter != nil {
+			retryAfter = *abuseRateLimitError.RetryAfter
+		}
+		return time.Now().Add(retryAfter), true
 	}
-	githubClient = github.NewClient(transport.Client())
+	return time.Time{}, false
 }
 
-func fetch(from string) (*github.RepositoryRelease, error) {
+func fetchOnce(ctx context.Context, from string, tag string, latest latestSelection) (*github.RepositoryRelease, error) {
 	names := strings.SplitN(from, "/", 2)
-	latestRelease, _, err := githubClient.Repositories.GetLatestRelease(context.Background(), names[0], names[1])
+	if tag != "" {
+		taggedRelease, response, err := githubClient.Repositories.GetReleaseByTag(ctx, names[0], names[1], tag)
+		logRateLimit(response)
+		if err != nil {
+			return nil, err
+		}
+		return taggedRelease, nil
+	}
+	if latest.list {
+		return fetchListed(ctx, names[0], names[1], latest.prerelease)
+	}
+	latestRelease, response, err := githubClient.Repositories.GetLatestRelease(ctx, names[0], names[1])
+	logRateLimit(response)
 	if err != nil {
 		return nil, err
 	}
 	return latestRelease, err
 }
 
-func get(downloadURL *string) ([]byte, error) {
-	log.Info("download ", *downloadURL)
-	response, err := http.Get(*downloadURL)
-	if err != nil {
-		return nil, err
+// fetchListed returns the first non-draft release of the release list, which
+// is ordered newest first, skipping prereleases unless prerelease is set.
+func fetchListed(ctx context.Context, owner string, repository string, prerelease bool) (*github.RepositoryRelease, error) {
+	listOptions := &github.ListOptions{PerPage: 100}
+	for {
+		releases, response, err := githubClient.Repositories.ListReleases(ctx, owner, repository, listOptions)
+		logRateLimit(response)
+		if err != nil {
+			return nil, err
+		}
+		for _, release := range releases {
+			if release.GetDraft() || (release.GetPrerelease() && !prerelease) {
+				continue
+			}
+			return release, nil
+		}
+		if response.NextPage == 0 {
+			return nil, E.New("no published release in ", owner, "/", repository)
+		}
+		listOptions.Page = response.NextPage
 	}
-	defer response.Body.Close()
-	return io.ReadAll(response.Body)
 }
 
-func download(release *github.RepositoryRelease) ([]byte, error) {
-	geositeAsset := common.Find(release.Assets, func(it *github.ReleaseAsset) bool {
-		return *it.Name == "geosite.dat"
-	})
-	geositeChecksumAsset := common.Find(release.Assets, func(it *github.ReleaseAsset) bool {
-		return *it.Name == "geosite.dat.sha256sum"
+// logRateLimit reports the core rate limit once, 5000 when the token is
+// accepted and 60 for anonymous requests.
+func logRateLimit(response *github.Response) {
+	if response == nil || response.Rate.Limit == 0 {
+		return
+	}
+	logRateLimitOnce.Do(func() {
+		geositegen.Info("github api rate limit", geositegen.F("limit", response.Rate.Limit), geositegen.F("remaining", response.Rate.Remaining), geositegen.F("authenticated", githubAuthenticated))
+		if githubAuthenticated && response.Rate.Limit <= 60 {
+			geositegen.Warn("github api rate limit is the anonymous one, ACCESS_TOKEN may be rejected")
+		}
 	})
-	if geositeAsset == nil {
-		return nil, E.New("geosite asset not found in upstream release ", release.Name)
+}
+
+// loadSource parses the geosite asset of release, recording its checksum as
+// downloaded, that of the file if the asset has none.
+func loadSource(repository string, release *github.RepositoryRelease, asset geositegen.DownloadedAsset, options Options) (map[string][]geosite.Item, provenanceSource, error) {
+	dataPath, checksum := asset.Path, asset.SHA256
+	var err error
+	if checksum == "" {
+		checksum, err = geositegen.FileSHA256(dataPath)
+		if err != nil {
+			return nil, provenanceSource{}, err
+		}
+	}
+	source := provenanceSource{
+		Repository:  repository,
+		Tag:         release.GetTagName(),
+		Asset:       options.Asset,
+		SHA256:      checksum,
+		Duplicates:  make(map[string]int),
+		PublishedAt: release.GetPublishedAt().Time,
+	}
+	convertOptions := geositegen.ParseOptions{
+		SuffixOnly:  options.SuffixOnly,
+		NoRegex:     options.NoRegex,
+		LintRegex:   options.LintRegex,
+		Concurrency: options.Concurrency,
+		Duplicates:  source.Duplicates,
+	}
+	if len(options.TypeOverrides) > 0 {
+		convertOptions.TypeOverrides = make(map[string]map[routercommon.Domain_Type]routercommon.Domain_Type, len(options.TypeOverrides))
+		for code, overrides := range options.TypeOverrides {
+			typeOverrides := make(map[routercommon.Domain_Type]routercommon.Domain_Type, len(overrides))
+			for from, to := range overrides {
+				fromType, _ := geositegen.ParseDomainType(from)
+				toType, _ := geositegen.ParseDomainType(to)
+				typeOverrides[fromType] = toType
+			}
+			convertOptions.TypeOverrides[strings.ToLower(code)] = typeOverrides
+		}
+	}
+	var domainMap map[string][]geosite.Item
+	if options.SourceType == "archive" {
+		domainMap, err = geositegen.ParseArchive(dataPath, convertOptions)
+	} else {
+		var vData []byte
+		vData, err = os.ReadFile(dataPath)
+		if err != nil {
+			return nil, provenanceSource{}, err
+		}
+		domainMap, err = geositegen.Parse(vData, convertOptions)
+	}
+	if err != nil {
+		return nil, provenanceSource{}, err
+	}
+	return domainMap, source, nil
+}
+
+// resolveCommits records the commit the tag of each source points to, the
+// target commitish of a release is usually a branch name. Sources that fail to
+// resolve are recorded without a commit.
+func resolveCommits(ctx context.Context, sources []provenanceSource) {
+	for i, source := range sources {
+		owner, repository, _ := strings.Cut(source.Repository, "/")
+		commit, response, err := githubClient.Repositories.GetCommitSHA1(ctx, owner, repository, "refs/tags/"+source.Tag, "")
+		logRateLimit(response)
+		if err != nil {
+			geositegen.Warn("resolve source commit", geositegen.F("repository", source.Repository), geositegen.F("tag", source.Tag), geositegen.F("error", err))
+			continue
+		}
+		sources[i].Commit = commit
 	}
-	if geositeChecksumAsset == nil {
-		return nil, E.New("geosite asset not found in upstream release ", release.Name)
+}
+
+// loadSources parses the downloaded asset of each release, in the order of
+// options.Source.
+func loadSources(releases []*github.RepositoryRelease, assets []geositegen.DownloadedAsset, options Options, metrics *runMetrics) ([]map[string][]geosite.Item, []provenanceSource, error) {
+	start := time.Now()
+	defer measure(&metrics.Parse, &start)
+	domainMaps := make([]map[string][]geosite.Item, 0, len(releases))
+	sources := make([]provenanceSource, 0, len(releases))
+	for i, release := range releases {
+		domainMap, source, err := loadSource(options.Source[i], release, assets[i], options)
+		if err != nil {
+			return nil, nil, E.Cause(err, "load ", release.GetHTMLURL())
+		}
+		domainMaps = append(domainMaps, domainMap)
+		sources = append(sources, source)
 	}
-	data, err := get(geositeAsset.BrowserDownloadURL)
+	return domainMaps, sources, nil
+}
+
+// generate builds every output from the union of the codes of domainMaps, the
+// items of earlier sources come first.
+func generate(domainMaps []map[string][]geosite.Item, sources []provenanceSource, options Options, metrics *runMetrics) ([]geositegen.ManifestEntry, error) {
+	err := checkOutputPaths(options)
 	if err != nil {
 		return nil, err
 	}
-	remoteChecksum, err := get(geositeChecksumAsset.BrowserDownloadURL)
+	start := time.Now()
+	domainMap := geositegen.Union(domainMaps)
+	var hooks []geositegen.Hook
+	if options.ExtraDomains != "" {
+		extraDomains, err := readExtraDomains(options.ExtraDomains, geositegen.ParseOptions{SuffixOnly: options.SuffixOnly})
+		if err != nil {
+			return nil, err
+		}
+		hooks = append(hooks, extraDomains)
+	}
+	if options.DenyFile != "" {
+		denyList, err := readDenyList(options.DenyFile)
+		if err != nil {
+			return nil, err
+		}
+		hooks = append(hooks, denyList.Hook())
+	}
+	err = geositegen.ApplyHooks(domainMap, hooks)
 	if err != nil {
 		return nil, err
 	}
-	checksum := sha256.Sum256(data)
-	if hex.EncodeToString(checksum[:]) != string(remoteChecksum[:64]) {
-		return nil, E.New("checksum mismatch")
+	if options.Groups != "" {
+		groups, err := readGroups(options.Groups)
+		if err != nil {
+			return nil, err
+		}
+		addGroups(domainMap, groups)
 	}
-	return data, nil
-}
-
-func parse(vGeositeData []byte) (map[string][]geosite.Item, error) {
-	vGeositeList := routercommon.GeoSiteList{}
-	err := proto.Unmarshal(vGeositeData, &vGeositeList)
+	err = checkRequired(domainMap, options.Require)
 	if err != nil {
 		return nil, err
 	}
-	domainMap := make(map[string][]geosite.Item)
-	for _, vGeositeEntry := range vGeositeList.Entry {
-		code := strings.ToLower(vGeositeEntry.CountryCode)
-		domains := make([]geosite.Item, 0, len(vGeositeEntry.Domain)*2)
-		attributes := make(map[string][]*routercommon.Domain)
-		for _, domain := range vGeositeEntry.Domain {
-			if len(domain.Attribute) > 0 {
-				for _, attribute := range domain.Attribute {
-					attributes[attribute.Key] = append(attributes[attribute.Key], domain)
-				}
+	domainMap, err = geositegen.FilterCodes(domainMap, options.Include, options.Exclude)
+	if err != nil {
+		return nil, err
+	}
+	if options.CodeRegex != "" {
+		domainMap = geositegen.MatchCodes(domainMap, regexp.MustCompile(options.CodeRegex))
+	}
+	measure(&metrics.Parse, &start)
+	ruleCounts := make(map[string]int, len(domainMap))
+	for code, domains := range domainMap {
+		ruleCounts[code] = geositegen.RuleCount(geositegen.Compile(domains))
+	}
+	err = checkRuleCounts(ruleCounts, options)
+	if err != nil {
+		return nil, err
+	}
+	if options.DryRun {
+		return nil, dryRun(options.RuleSetOutput, ruleCounts)
+	}
+	if options.StatsJSON != "" {
+		err = writeStats(domainMap, sources, options.StatsJSON)
+		if err != nil {
+			return nil, err
+		}
+		metrics.WrittenFiles++
+	}
+	cnDomainMap := cnDomains(domainMap, options)
+	if !options.NoDB {
+		writtenFiles, err := writeDatabases(domainMap, cnDomainMap, options)
+		metrics.WrittenFiles += writtenFiles
+		if err != nil {
+			return nil, err
+		}
+	}
+	if options.CNRuleSetOutput != "" {
+		err = writeCNRuleSet(cnDomainMap, options)
+		if err != nil {
+			return nil, err
+		}
+		metrics.WrittenFiles++
+	}
+	if key := shardKey(options); key != nil {
+		writtenShards, err := writeShards(domainMap, key, options)
+		if err != nil {
+			return nil, err
+		}
+		metrics.WrittenFiles += writtenShards
+	}
+	ruleSetWriter, err := geositegen.NewRuleSetWriter(options.RuleSetOutput, options.Force)
+	if err != nil {
+		return nil, err
+	}
+	ruleSetWriter.Gzip = options.Gzip
+	ruleSetWriter.Sort = options.Sort
+	ruleSetWriter.Optimize = options.Optimize
+	ruleSetWriter.Verify = options.Verify
+	ruleSetWriter.VerifyOutput = options.VerifyOutput
+	ruleSetWriter.SkipSRS = !common.Contains(options.Formats, "srs")
+	ruleSetWriter.SkipJSON = !common.Contains(options.Formats, "json")
+	ruleSetWriter.AttributeSeparator = options.AttributeSeparator
+	ruleSetWriter.Nested = options.Nested
+	ruleSetWriter.JSONIndent = options.JSONIndent
+	ruleSetWriter.PruneEmpty = options.PruneEmpty
+	ruleSetWriter.Text = options.Text
+	if options.VersionFiles {
+		ruleSetWriter.Version = strings.Join(common.Map(sources, func(it provenanceSource) string {
+			return it.Tag
+		}), ",")
+	}
+	if options.ChangedOnly {
+		ruleSetWriter.Previous = previousRuleSets(options.RuleSetOutput)
+	}
+	// Rewritten files carry the time of the newest source release so that
+	// their Last-Modified only changes along with their content.
+	for _, source := range sources {
+		if source.PublishedAt.After(ruleSetWriter.ModTime) {
+			ruleSetWriter.ModTime = source.PublishedAt
+		}
+	}
+	manifestEntries, err := ruleSetWriter.WriteRuleSets(options.Prefix, geositegen.SortedCodes(domainMap), options.Concurrency, func(code string) option.DefaultHeadlessRule {
+		return geositegen.Compile(domainMap[code])
+	})
+	if err != nil {
+		return nil, err
+	}
+	if options.All {
+		if _, loaded := domainMap["all"]; loaded {
+			geositegen.Warn("code all exists upstream, skipped combined rule set")
+		} else {
+			manifestEntry, err := ruleSetWriter.WriteRuleSet(ruleSetWriter.RuleSetName(options.Prefix, "all"), geositegen.Compile(geositegen.Merge(domainMap, geositegen.SortedCodes(domainMap))))
+			if err != nil {
+				return nil, err
 			}
-			switch domain.Type {
-			case routercommon.Domain_Plain:
-				domains = append(domains, geosite.Item{
-					Type:  geosite.RuleTypeDomainKeyword,
-					Value: domain.Value,
-				})
-			case routercommon.Domain_Regex:
-				domains = append(domains, geosite.Item{
-					Type:  geosite.RuleTypeDomainRegex,
-					Value: domain.Value,
-				})
-			case routercommon.Domain_RootDomain:
-				if strings.Contains(domain.Value, ".") {
-					domains = append(domains, geosite.Item{
-						Type:  geosite.RuleTypeDomain,
-						Value: domain.Value,
-					})
+			manifestEntry.Code = "all"
+			manifestEntries = append(manifestEntries, manifestEntry)
+		}
+	}
+	if len(options.Except) > 0 {
+		if _, loaded := domainMap["all-except"]; loaded {
+			geositegen.Warn("code all-except exists upstream, skipped complement rule set")
+		} else {
+			for _, code := range options.Except {
+				if _, loaded := domainMap[code]; !loaded {
+					geositegen.Warn("missing except code", geositegen.F("code", code))
 				}
-				domains = append(domains, geosite.Item{
-					Type:  geosite.RuleTypeDomainSuffix,
-					Value: "." + domain.Value,
-				})
-			case routercommon.Domain_Full:
-				domains = append(domains, geosite.Item{
-					Type:  geosite.RuleTypeDomain,
-					Value: domain.Value,
-				})
 			}
+			manifestEntry, err := ruleSetWriter.WriteRuleSet(ruleSetWriter.RuleSetName(options.Prefix, "all-except"), geositegen.Compile(geositegen.Complement(domainMap, options.Except)))
+			if err != nil {
+				return nil, err
+			}
+			manifestEntry.Code = "all-except"
+			manifestEntries = append(manifestEntries, manifestEntry)
 		}
-		domainMap[code] = common.Uniq(domains)
-		for attribute, attributeEntries := range attributes {
-			attributeDomains := make([]geosite.Item, 0, len(attributeEntries)*2)
-			for _, domain := range attributeEntries {
-				switch domain.Type {
-				case routercommon.Domain_Plain:
-					attributeDomains = append(attributeDomains, geosite.Item{
-						Type:  geosite.RuleTypeDomainKeyword,
-						Value: domain.Value,
-					})
-				case routercommon.Domain_Regex:
-					attributeDomains = append(attributeDomains, geosite.Item{
-						Type:  geosite.RuleTypeDomainRegex,
-						Value: domain.Value,
-					})
-				case routercommon.Domain_RootDomain:
-					if strings.Contains(domain.Value, ".") {
-						attributeDomains = append(attributeDomains, geosite.Item{
-							Type:  geosite.RuleTypeDomain,
-							Value: domain.Value,
-						})
-					}
-					attributeDomains = append(attributeDomains, geosite.Item{
-						Type:  geosite.RuleTypeDomainSuffix,
-						Value: "." + domain.Value,
-					})
-				case routercommon.Domain_Full:
-					attributeDomains = append(attributeDomains, geosite.Item{
-						Type:  geosite.RuleTypeDomain,
-						Value: domain.Value,
-					})
-				}
+	}
+	if options.CodeRegexMerged != "" {
+		if _, loaded := domainMap[options.CodeRegexMerged]; loaded {
+			geositegen.Warn("merged code exists upstream, skipped merged rule set", geositegen.F("code", options.CodeRegexMerged))
+		} else {
+			manifestEntry, err := ruleSetWriter.WriteRuleSet(ruleSetWriter.RuleSetName(options.Prefix, options.CodeRegexMerged), geositegen.Compile(geositegen.Merge(domainMap, geositegen.SortedCodes(domainMap))))
+			if err != nil {
+				return nil, err
 			}
-			domainMap[code+"@"+attribute] = common.Uniq(attributeDomains)
+			manifestEntry.Code = options.CodeRegexMerged
+			manifestEntries = append(manifestEntries, manifestEntry)
 		}
 	}
-	return domainMap, nil
+	if options.Optimize {
+		geositegen.Info("pruned redundant domain suffixes", geositegen.F("pruned", ruleSetWriter.PrunedSuffixes()))
+	}
+	if options.ChangedOnly {
+		geositegen.Info("kept unchanged rule sets", geositegen.F("rule_sets", ruleSetWriter.ReusedRuleSets()))
+	}
+	if emptyCodes := ruleSetWriter.EmptyCodes(); len(emptyCodes) > 0 {
+		geositegen.Info("pruned empty codes", geositegen.F("codes", strings.Join(emptyCodes, ",")))
+	}
+	err = ruleSetWriter.WriteManifest(manifestEntries)
+	if err != nil {
+		return nil, err
+	}
+	err = writeProvenance(ruleSetWriter, sources, options.Deterministic)
+	if err != nil {
+		return nil, err
+	}
+	err = ruleSetWriter.Close()
+	if err != nil {
+		return nil, err
+	}
+	if options.Archive != "" {
+		err = geositegen.WriteBundle(options.RuleSetOutput, options.Archive)
+		if err != nil {
+			return nil, err
+		}
+		metrics.WrittenFiles++
+	}
+	measure(&metrics.Generate, &start)
+	metrics.WrittenFiles += ruleSetWriter.WrittenFiles()
+	return manifestEntries, nil
+}
+
+// attributesOf returns the sorted attributes of code in domainMap.
+func attributesOf(domainMap map[string][]geosite.Item, code string) []string {
+	var attributes []string
+	for key := range domainMap {
+		if strings.HasPrefix(key, code+"@") {
+			attributes = append(attributes, strings.TrimPrefix(key, code+"@"))
+		}
+	}
+	sort.Strings(attributes)
+	return attributes
 }
 
-func generate(release *github.RepositoryRelease, output string, cnOutput string, ruleSetOutput string) error {
-	vData, err := download(release)
+// cnDomains returns the cn codes of domainMap, any code@attribute of the
+// upstream data can be listed.
+func cnDomains(domainMap map[string][]geosite.Item, options Options) map[string][]geosite.Item {
+	cnDomainMap := make(map[string][]geosite.Item)
+	for _, cnCode := range options.CNCodes {
+		domains, loaded := domainMap[cnCode]
+		if !loaded {
+			code, attribute, isAttribute := strings.Cut(cnCode, "@")
+			if _, codeLoaded := domainMap[code]; isAttribute && codeLoaded {
+				geositegen.Warn("missing attribute of cn code, skipped", geositegen.F("code", code), geositegen.F("attribute", attribute), geositegen.F("attributes", strings.Join(attributesOf(domainMap, code), ",")))
+			} else {
+				geositegen.Warn("missing cn code, skipped", geositegen.F("code", cnCode))
+			}
+			continue
+		}
+		cnDomainMap[cnCode] = domains
+	}
+	return cnDomainMap
+}
+
+// writeDatabases writes domainMap as the geosite database and cnDomainMap as
+// the cn geosite database, and returns the number of files written.
+func writeDatabases(domainMap map[string][]geosite.Item, cnDomainMap map[string][]geosite.Item, options Options) (int, error) {
+	for _, outputPath := range []string{options.Output, options.CNOutput} {
+		err := os.MkdirAll(filepath.Dir(outputPath), 0o755)
+		if err != nil {
+			return 0, E.Cause(err, "create directory of ", outputPath)
+		}
+	}
+	writtenFiles, err := writeDatabase(options.Output, domainMap)
 	if err != nil {
-		return err
+		return writtenFiles, E.Cause(err, "output")
 	}
-	domainMap, err := parse(vData)
+	writtenCNFiles, err := writeDatabase(options.CNOutput, cnDomainMap)
+	writtenFiles += writtenCNFiles
 	if err != nil {
-		return err
+		return writtenFiles, E.Cause(err, "cn_output")
 	}
-	outputPath, _ := filepath.Abs(output)
-	os.Stderr.WriteString("write " + outputPath + "\n")
-	outputFile, err := os.Create(output)
+	return writtenFiles, nil
+}
+
+// writeDatabase writes domainMap as a geosite database and its checksum to
+// outputPath and returns the number of files written, errors name the stage
+// that failed.
+func writeDatabase(outputPath string, domainMap map[string][]geosite.Item) (int, error) {
+	absPath, _ := filepath.Abs(outputPath)
+	geositegen.LogFile("write", absPath)
+	outputFile, err := geositegen.CreateAtomic(outputPath)
 	if err != nil {
-		return err
+		return 0, E.Cause(err, "create ", absPath)
 	}
 	defer outputFile.Close()
 	err = geosite.Write(outputFile, domainMap)
 	if err != nil {
-		return err
+		return 0, E.Cause(err, "encode geosite database ", absPath)
 	}
-	cnCodes := []string{
-		"cn",
-		"geolocation-!cn",
-		"category-companies@cn",
+	err = outputFile.Commit()
+	if err != nil {
+		return 0, E.Cause(err, "commit ", absPath)
 	}
-	cnDomainMap := make(map[string][]geosite.Item)
-	for _, cnCode := range cnCodes {
-		cnDomainMap[cnCode] = domainMap[cnCode]
+	err = writeChecksum(outputPath)
+	if err != nil {
+		return 1, E.Cause(err, "write checksum of ", absPath)
 	}
-	cnOutputFile, err := os.Create(cnOutput)
+	return 2, nil
+}
+
+// writeChecksum writes the SHA256 of the file at path to path.sha256sum, in
+// the sha256sum format upstream publishes.
+func writeChecksum(path string) error {
+	checksum, err := geositegen.FileSHA256(path)
 	if err != nil {
 		return err
 	}
-	defer cnOutputFile.Close()
-	err = geosite.Write(cnOutputFile, cnDomainMap)
+	checksumPath, _ := filepath.Abs(path + ".sha256sum")
+	geositegen.LogFile("write", checksumPath)
+	return geositegen.WriteFileAtomic(checksumPath, []byte(checksum+"  "+filepath.Base(path)+"\n"))
+}
+
+// cnRuleSet returns the union of the cn codes as a single rule.
+func cnRuleSet(cnDomainMap map[string][]geosite.Item, options Options) option.DefaultHeadlessRule {
+	headlessRule := geositegen.Compile(geositegen.Merge(cnDomainMap, options.CNCodes))
+	if options.Optimize {
+		headlessRule.DomainSuffix, _ = geositegen.OptimizeSuffixes(headlessRule.DomainSuffix)
+	}
+	if options.Sort {
+		geositegen.SortRule(&headlessRule)
+	}
+	return headlessRule
+}
+
+// writeCNRuleSet writes cnRuleSet as a binary rule set.
+func writeCNRuleSet(cnDomainMap map[string][]geosite.Item, options Options) error {
+	err := os.MkdirAll(filepath.Dir(options.CNRuleSetOutput), 0o755)
 	if err != nil {
-		return err
+		return E.Cause(err, "cn_rule_set_output")
 	}
-	os.RemoveAll(ruleSetOutput)
-	err = os.MkdirAll(ruleSetOutput, 0o755)
+	outputPath, _ := filepath.Abs(options.CNRuleSetOutput)
+	geositegen.LogFile("write", outputPath)
+	outputFile, err := geositegen.CreateAtomic(options.CNRuleSetOutput)
 	if err != nil {
-		return err
+		return E.Cause(err, "cn_rule_set_output: create ", outputPath)
 	}
-	for code, domains := range domainMap {
-		var headlessRule option.DefaultHeadlessRule
-		defaultRule := geosite.Compile(domains)
-		headlessRule.Domain = defaultRule.Domain
-		headlessRule.DomainSuffix = defaultRule.DomainSuffix
-		headlessRule.DomainKeyword = defaultRule.DomainKeyword
-		headlessRule.DomainRegex = defaultRule.DomainRegex
-		var plainRuleSet option.PlainRuleSet
-		plainRuleSet.Rules = []option.HeadlessRule{
-			{
-				Type:           C.RuleTypeDefault,
-				DefaultOptions: headlessRule,
-			},
-		}
-		srsPath, _ := filepath.Abs(filepath.Join(ruleSetOutput, "geosite-"+code+".srs"))
-		os.Stderr.WriteString("write " + srsPath + "\n")
-		outputRuleSet, err := os.Create(srsPath)
-		if err != nil {
-			return err
+	defer outputFile.Close()
+	err = geositegen.WriteSRS(outputFile, cnRuleSet(cnDomainMap, options))
+	if err != nil {
+		return E.Cause(err, "cn_rule_set_output: encode rule set ", outputPath)
+	}
+	err = outputFile.Commit()
+	if err != nil {
+		return E.Cause(err, "cn_rule_set_output: commit ", outputPath)
+	}
+	return nil
+}
+
+// checkOutputPaths fails if two outputs resolve to the same path or one lies
+// inside the directory of another, so that one would overwrite the other.
+func checkOutputPaths(options Options) error {
+	type output struct {
+		name string
+		path string
+	}
+	outputs := []output{
+		{"rule_set_output", options.RuleSetOutput},
+		{"cn_rule_set_output", options.CNRuleSetOutput},
+		{"archive", options.Archive},
+		{"geoip_rule_set_output", options.GeoIPRuleSetOutput},
+	}
+	if !options.NoDB {
+		outputs = append(outputs, output{"output", options.Output}, output{"cn_output", options.CNOutput})
+	}
+	if shardKey(options) != nil {
+		outputs = append(outputs, output{"db_shard_output", options.DBShardOutput})
+	}
+	var resolved []output
+	for _, it := range outputs {
+		if it.path == "" {
+			continue
 		}
-		err = srs.Write(outputRuleSet, plainRuleSet)
-		if err != nil {
-			outputRuleSet.Close()
-			return err
+		outputPath := resolvePath(it.path)
+		for _, existing := range resolved {
+			switch {
+			case existing.path == outputPath:
+				return E.New("outputs ", existing.name, " and ", it.name, " are both ", outputPath)
+			case isWithin(existing.path, outputPath):
+				return E.New("output ", it.name, " is inside output ", existing.name, ": ", outputPath)
+			case isWithin(outputPath, existing.path):
+				return E.New("output ", existing.name, " is inside output ", it.name, ": ", existing.path)
+			}
 		}
-		outputRuleSet.Close()
+		resolved = append(resolved, output{it.name, outputPath})
+	}
+	return nil
+}
 
-		srsPath, _ = filepath.Abs(filepath.Join(ruleSetOutput, "geosite-"+code+".json"))
-		os.Stderr.WriteString("write " + srsPath + "\n")
-		outputRuleSet, err = os.Create(srsPath)
-		if err != nil {
-			return err
+// isWithin reports whether path lies strictly inside the directory dir.
+func isWithin(dir string, path string) bool {
+	relPath, err := filepath.Rel(dir, path)
+	if err != nil || relPath == "." {
+		return false
+	}
+	return relPath != ".." && !strings.HasPrefix(relPath, ".."+string(filepath.Separator))
+}
+
+// resolvePath returns the absolute path of path with symlinks resolved, those
+// of its directory only if path does not exist yet.
+func resolvePath(path string) string {
+	absPath, _ := filepath.Abs(path)
+	if resolvedPath, err := filepath.EvalSymlinks(absPath); err == nil {
+		return resolvedPath
+	}
+	if resolvedDir, err := filepath.EvalSymlinks(filepath.Dir(absPath)); err == nil {
+		return filepath.Join(resolvedDir, filepath.Base(absPath))
+	}
+	return absPath
+}
+
+// checkRequired fails if any of the required codes is missing upstream.
+func checkRequired(domainMap map[string][]geosite.Item, required []string) error {
+	var missing []string
+	for _, code := range required {
+		if _, loaded := domainMap[strings.ToLower(code)]; !loaded {
+			missing = append(missing, code)
 		}
-		je := json.NewEncoder(outputRuleSet)
-		je.SetEscapeHTML(false)
-		je.SetIndent("", "    ")
-		err = je.Encode(plainRuleSet)
-		if err != nil {
-			outputRuleSet.Close()
-			return err
+	}
+	if len(missing) > 0 {
+		return E.New("missing required codes: ", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+func checkRuleCounts(ruleCounts map[string]int, options Options) error {
+	if options.MaxRules == 0 {
+		return nil
+	}
+	var exceededCodes []string
+	for _, code := range geositegen.SortedCodes(ruleCounts) {
+		if ruleCounts[code] > options.MaxRules {
+			geositegen.Warn("too many rules", geositegen.F("code", code), geositegen.F("rules", ruleCounts[code]), geositegen.F("max_rules", options.MaxRules))
+			exceededCodes = append(exceededCodes, code)
 		}
-		outputRuleSet.Close()
+	}
+	if options.ErrorOnMax && len(exceededCodes) > 0 {
+		return E.New("codes exceeding ", options.MaxRules, " rules: ", strings.Join(exceededCodes, ", "))
 	}
 	return nil
 }
 
+// isLatest reports whether destination was built from source, preferring the
+// source-tag line of the destination release body over the release names.
+// Destinations recording source-sha256 are left to sourceChecksumMatches.
+func isLatest(source *github.RepositoryRelease, destination *github.RepositoryRelease) bool {
+	if _, loaded := releaseBodyField(destination.GetBody(), "source-sha256"); loaded {
+		return false
+	}
+	sourceTag, loaded := releaseBodyField(destination.GetBody(), "source-tag")
+	if loaded {
+		return sourceTag == source.GetTagName()
+	}
+	return destination.GetName() != "" && destination.GetName() == source.GetName()
+}
+
+func isTerminal(file *os.File) bool {
+	info, err := file.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// sinceTime resolves the since option, destination standing for the publish
+// time of the destination release.
+func sinceTime(since string, destination *github.RepositoryRelease) (time.Time, bool) {
+	switch since {
+	case "":
+		return time.Time{}, false
+	case "destination":
+		if destination == nil || destination.PublishedAt == nil {
+			return time.Time{}, false
+		}
+		return destination.GetPublishedAt().Time, true
+	}
+	sinceTime, _ := time.Parse(time.RFC3339, since)
+	return sinceTime, true
+}
+
+// sourceChecksum returns the SHA256 of the loaded assets as recorded in the
+// source-sha256 line of the release body, comma separated if several.
+func sourceChecksum(sources []provenanceSource) string {
+	return strings.Join(common.Map(sources, func(it provenanceSource) string {
+		return it.SHA256
+	}), ",")
+}
+
+// sourceChecksumMatches reports whether the source-sha256 line of the
+// destination release body equals checksum.
+func sourceChecksumMatches(destination *github.RepositoryRelease, checksum string) bool {
+	recorded, loaded := releaseBodyField(destination.GetBody(), "source-sha256")
+	return loaded && recorded != "" && strings.EqualFold(recorded, checksum)
+}
+
+func releaseBodyField(body string, key string) (string, bool) {
+	for _, line := range strings.Split(body, "\n") {
+		name, value, found := strings.Cut(strings.TrimSpace(line), ":")
+		if found && strings.TrimSpace(name) == key {
+			return strings.TrimSpace(value), true
+		}
+	}
+	return "", false
+}
+
 func setActionOutput(name string, content string) {
-	os.Stdout.WriteString("::set-output name=" + name + "::" + content + "\n")
+	outputPath := os.Getenv("GITHUB_OUTPUT")
+	if outputPath == "" {
+		os.Stdout.WriteString("::set-output name=" + name + "::" + content + "\n")
+		return
+	}
+	outputFile, err := os.OpenFile(outputPath, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		geositegen.Warn("open GITHUB_OUTPUT", geositegen.F("path", outputPath), geositegen.F("error", err))
+		os.Stdout.WriteString("::set-output name=" + name + "::" + content + "\n")
+		return
+	}
+	defer outputFile.Close()
+	if !strings.Contains(content, "\n") {
+		outputFile.WriteString(name + "=" + content + "\n")
+		return
+	}
+	var delimiterBytes [8]byte
+	rand.Read(delimiterBytes[:])
+	delimiter := "EOF_" + hex.EncodeToString(delimiterBytes[:])
+	outputFile.WriteString(name + "<<" + delimiter + "\n" + content + "\n" + delimiter + "\n")
+}
+
+// newDownloader returns a downloader configured by options.
+func newDownloader(options Options) *geositegen.Downloader {
+	downloader := &geositegen.Downloader{
+		Client:          geositegen.NewHTTPClient(time.Duration(options.DownloadTimeout)),
+		MaxAttempts:     options.MaxAttempts,
+		AllowUnverified: options.AllowUnverified,
+		ChecksumWarn:    options.ChecksumWarn,
+		MaxConcurrent:   options.DownloadConcurrency,
+		MaxBytes:        options.MaxDownloadBytes,
+	}
+	if isTerminal(os.Stdout) {
+		downloader.Progress = os.Stdout
+	}
+	if !options.NoCache {
+		downloader.CacheDir = options.CacheDir
+		if downloader.CacheDir == "" {
+			userCacheDir, err := os.UserCacheDir()
+			if err != nil {
+				geositegen.Warn("cache disabled", geositegen.F("error", err))
+			} else {
+				downloader.CacheDir = filepath.Join(userCacheDir, "sing-geosite")
+			}
+		}
+	}
+	return downloader
 }
 
-func release(source string, destination string, output string, cnOutput string, ruleSetOutput string) error {
-	sourceRelease, err := fetch(source)
+func release(ctx context.Context, options Options) error {
+	var metrics runMetrics
+	runStart := time.Now()
+	start := runStart
+	geositegen.Info("github api", geositegen.F("authenticated", githubAuthenticated))
+	sourceLatest := latestSelection{
+		list:       options.ListReleases,
+		prerelease: options.Prerelease,
+	}
+	sourceRelease, err := fetch(ctx, options.Source[0], options.SourceTag, sourceLatest, options.WaitRateLimit)
 	if err != nil {
 		return err
 	}
-	destinationRelease, err := fetch(destination)
+	sourceReleases := []*github.RepositoryRelease{sourceRelease}
+	for _, source := range options.Source[1:] {
+		extraRelease, err := fetch(ctx, source, "", sourceLatest, options.WaitRateLimit)
+		if err != nil {
+			return err
+		}
+		sourceReleases = append(sourceReleases, extraRelease)
+	}
+	destinationRelease, err := fetch(ctx, options.Destination, "", latestSelection{}, options.WaitRateLimit)
 	if err != nil {
-		log.Warn("missing destination latest release")
+		geositegen.Warn("missing destination latest release", geositegen.F("repository", options.Destination), geositegen.F("error", err))
 	} else {
-		if os.Getenv("NO_SKIP") != "true" && strings.Contains(*destinationRelease.Name, *sourceRelease.Name) {
-			log.Info("already latest")
+		if os.Getenv("NO_SKIP") != "true" && isLatest(sourceRelease, destinationRelease) {
+			geositegen.Info("already latest", geositegen.F("tag", sourceRelease.GetTagName()))
+			setActionOutput("skip", "true")
+			return nil
+		}
+	}
+	if since, loaded := sinceTime(options.Since, destinationRelease); loaded && os.Getenv("NO_SKIP") != "true" {
+		publishedAt := sourceRelease.GetPublishedAt().Time
+		if !publishedAt.After(since) {
+			geositegen.Info("upstream not newer", geositegen.F("tag", sourceRelease.GetTagName()), geositegen.F("published_at", publishedAt.Format(time.RFC3339)), geositegen.F("since", since.Format(time.RFC3339)))
 			setActionOutput("skip", "true")
 			return nil
 		}
 	}
-	err = generate(sourceRelease, output, cnOutput, ruleSetOutput)
+	measure(&metrics.Fetch, &start)
+	if options.OutputDir != "" && !options.DryRun {
+		err = os.MkdirAll(options.OutputDir, 0o755)
+		if err != nil {
+			return err
+		}
+	}
+	downloader := newDownloader(options)
+	start = time.Now()
+	previousManifest, hasPreviousManifest := loadPreviousManifest(ctx, downloader, destinationRelease, options)
+	downloadRequests := make([]geositegen.DownloadRequest, 0, len(sourceReleases)+1)
+	for _, release := range sourceReleases {
+		downloadRequests = append(downloadRequests, geositegen.DownloadRequest{
+			Release:       release,
+			Asset:         options.Asset,
+			ChecksumAsset: options.ChecksumAsset,
+			Gunzip:        options.gunzipAsset(),
+		})
+	}
+	if options.GeoIPRuleSetOutput != "" {
+		downloadRequests = append(downloadRequests, geositegen.DownloadRequest{
+			Release: sourceRelease,
+			Asset:   "geoip.dat",
+		})
+	}
+	assets, err := downloader.DownloadAll(ctx, downloadRequests)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		for _, asset := range assets {
+			os.Remove(asset.Path)
+		}
+	}()
+	measure(&metrics.Download, &start)
+	domainMaps, sources, err := loadSources(sourceReleases, assets, options, &metrics)
+	if err != nil {
+		return err
+	}
+	checksum := sourceChecksum(sources)
+	if destinationRelease != nil && os.Getenv("NO_SKIP") != "true" && sourceChecksumMatches(destinationRelease, checksum) {
+		geositegen.Info("already latest", geositegen.F("tag", sourceRelease.GetTagName()), geositegen.F("sha256", checksum))
+		setActionOutput("skip", "true")
+		return nil
+	}
+	resolveCommits(ctx, sources)
+	manifestEntries, err := generate(domainMaps, sources, options, &metrics)
+	if err != nil {
+		return err
+	}
+	if manifestEntries != nil {
+		if hasPreviousManifest {
+			err = reportChanges(previousManifest, manifestEntries, options)
+			if err != nil {
+				return err
+			}
+		} else {
+			geositegen.Info("no previous manifest, skipped change report")
+		}
+		if options.SummaryFile != "" {
+			var previous *geositegen.Manifest
+			if hasPreviousManifest {
+				previous = &previousManifest
+			}
+			err = writeSummary(sources, previous, manifestEntries, options)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if options.GeoIPRuleSetOutput != "" {
+		err = generateGeoIP(assets[len(sourceReleases)].Path, options, &metrics)
+		if err != nil {
+			return err
+		}
+	}
+	if options.Publish && !options.DryRun {
+		err = publish(ctx, sourceRelease, checksum, options)
+		if err != nil {
+			return err
+		}
+	}
+	metrics.Total = option.Duration(time.Since(runStart))
+	metrics.DownloadedBytes = downloader.DownloadedBytes()
+	err = metrics.report(options.MetricsFile, options.DryRun)
 	if err != nil {
 		return err
 	}
 	setActionOutput("tag", *sourceRelease.Name)
+	setActionOutput("source_tag", sourceRelease.GetTagName())
+	setActionOutput("source_sha256", checksum)
 	return nil
 }
 
+// releaseLocal builds the geosite outputs from the asset at options.LocalDat,
+// without fetching, downloading or comparing any release.
+func releaseLocal(options Options) error {
+	var metrics runMetrics
+	runStart := time.Now()
+	geositegen.Info("local source, skipped fetch and release comparison", geositegen.F("path", options.LocalDat))
+	if options.GeoIPRuleSetOutput != "" {
+		geositegen.Warn("local source, skipped geoip rule sets")
+	}
+	if options.OutputDir != "" && !options.DryRun {
+		err := os.MkdirAll(options.OutputDir, 0o755)
+		if err != nil {
+			return err
+		}
+	}
+	start := time.Now()
+	domainMap, source, err := loadSource("local", nil, geositegen.DownloadedAsset{Path: options.LocalDat}, options)
+	if err != nil {
+		return E.Cause(err, "load ", options.LocalDat)
+	}
+	source.Asset = options.LocalDat
+	measure(&metrics.Parse, &start)
+	manifestEntries, err := generate([]map[string][]geosite.Item{domainMap}, []provenanceSource{source}, options, &metrics)
+	if err != nil {
+		return err
+	}
+	if manifestEntries != nil && options.SummaryFile != "" {
+		err = writeSummary([]provenanceSource{source}, nil, manifestEntries, options)
+		if err != nil {
+			return err
+		}
+	}
+	metrics.Total = option.Duration(time.Since(runStart))
+	return metrics.report(options.MetricsFile, options.DryRun)
+}
+
 func main() {
-	err := release(
-		"Loyalsoldier/v2ray-rules-dat",
-		"minoriazure/sing-geosite",
-		"geosite.db",
-		"geosite-cn.db",
-		"rule-set",
-	)
-	if err != nil {
-		log.Fatal(err)
+	if len(os.Args) > 1 && os.Args[1] == "validate" {
+		if len(os.Args) < 3 {
+			geositegen.Fatal("usage: " + os.Args[0] + " validate <file.srs>...")
+		}
+		for _, path := range os.Args[2:] {
+			err := validate(path)
+			if err != nil {
+				geositegen.Fatal("validate", geositegen.F("path", path), geositegen.F("error", err))
+			}
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "list" {
+		if len(os.Args) != 3 {
+			geositegen.Fatal("usage: " + os.Args[0] + " list <geosite.db>")
+		}
+		err := list(os.Args[2])
+		if err != nil {
+			geositegen.Fatal("list", geositegen.F("path", os.Args[2]), geositegen.F("error", err))
+		}
+		return
+	}
+	arguments := os.Args[1:]
+	isSelftest := len(arguments) > 0 && arguments[0] == "selftest"
+	if isSelftest {
+		arguments = arguments[1:]
+	}
+	options, err := parseOptions(arguments)
+	if err != nil {
+		geositegen.Fatal("parse options", geositegen.F("error", err))
+	}
+	err = geositegen.SetLogFormat(options.LogFormat)
+	if err != nil {
+		geositegen.Fatal("parse options", geositegen.F("error", err))
+	}
+	geositegen.SetQuiet(options.Quiet)
+	geositegen.Info("concurrency", geositegen.F("downloads", options.DownloadConcurrency), geositegen.F("writes", options.Concurrency))
+	err = setupGitHubClient(options.GitHubURL, geositegen.NewHTTPClient(time.Duration(options.DownloadTimeout)))
+	if err != nil {
+		geositegen.Fatal("parse options", geositegen.F("error", err))
+	}
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+	if options.Timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(options.Timeout))
+		defer cancel()
+	}
+	if isSelftest {
+		err = selftest(ctx, options)
+		if err != nil {
+			geositegen.Fatal("selftest", geositegen.F("error", err))
+		}
+		return
+	}
+	if options.LocalDat != "" {
+		err = releaseLocal(options)
+	} else {
+		err = release(ctx, options)
+	}
+	if err != nil {
+		geositegen.Fatal("release", geositegen.F("error", err))
 	}
 }
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestCheckOutputPaths(t *testing.T) {
+	dir := t.TempDir()
+	for _, testCase := range []struct {
+		name    string
+		options Options
+		invalid bool
+	}{
+		{"distinct", Options{RuleSetOutput: filepath.Join(dir, "rule-set"), GeoIPRuleSetOutput: filepath.Join(dir, "rule-set-geoip")}, false},
+		{"same", Options{RuleSetOutput: filepath.Join(dir, "rule-set"), GeoIPRuleSetOutput: filepath.Join(dir, "rule-set")}, true},
+		{"nested", Options{RuleSetOutput: filepath.Join(dir, "rule-set"), CNRuleSetOutput: filepath.Join(dir, "rule-set", "cn")}, true},
+		{"parent", Options{NoDB: true, RuleSetOutput: filepath.Join(dir, "rule-set", "all"), Archive: filepath.Join(dir, "rule-set")}, true},
+		{"shards", Options{NoDB: true, RuleSetOutput: filepath.Join(dir, "rule-set"), DBShard: "letter", DBShardOutput: filepath.Join(dir, "rule-set")}, true},
+		{"shards disabled", Options{NoDB: true, RuleSetOutput: filepath.Join(dir, "rule-set"), DBShardOutput: filepath.Join(dir, "rule-set")}, false},
+	} {
+		err := checkOutputPaths(testCase.options)
+		if testCase.invalid && err == nil {
+			t.Errorf("%s: expected an error", testCase.name)
+		} else if !testCase.invalid && err != nil {
+			t.Errorf("%s: %v", testCase.name, err)
+		}
+	}
+}
+
+func TestIsWithin(t *testing.T) {
+	for _, testCase := range []struct {
+		path   string
+		within bool
+	}{
+		{filepath.Join("rule-set", "geosite-cn.srs"), true},
+		{filepath.Join("rule-set", "..archive.tar.gz"), true},
+		{"rule-set", false},
+		{filepath.Join("rule-set", "..", "archive.tar.gz"), false},
+		{"rule-set-archive.tar.gz", false},
+	} {
+		if within := isWithin("rule-set", testCase.path); within != testCase.within {
+			t.Errorf("%s: within %v, expected %v", testCase.path, within, testCase.within)
+		}
+	}
+}
+
+func TestParseOptionsCodePatterns(t *testing.T) {
+	options, err := parseOptions([]string{"-include", "Google*,CN", "-exclude", "Google-CN"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(options.Include) != 2 || options.Include[0] != "google*" || options.Include[1] != "cn" {
+		t.Errorf("unexpected include patterns: %v", options.Include)
+	}
+	if len(options.Exclude) != 1 || options.Exclude[0] != "google-cn" {
+		t.Errorf("unexpected exclude patterns: %v", options.Exclude)
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"time"
+
+	"github.com/sagernet/sing-box/option"
+
+	"sing-geosite/geositegen"
+)
+
+// runMetrics accumulates where the time of a run goes.
+type runMetrics struct {
+	Fetch           option.Duration `json:"fetch"`
+	Download        option.Duration `json:"download"`
+	Parse           option.Duration `json:"parse"`
+	Generate        option.Duration `json:"generate"`
+	Total           option.Duration `json:"total"`
+	DownloadedBytes int64           `json:"downloaded_bytes"`
+	WrittenFiles    int             `json:"written_files"`
+}
+
+// measure adds the time elapsed since start to stage and restarts the clock.
+func measure(stage *option.Duration, start *time.Time) {
+	now := time.Now()
+	*stage += option.Duration(now.Sub(*start))
+	*start = now
+}
+
+// report logs the metrics and writes them to metricsOutput if not empty,
+// unless dryRun is set.
+func (m *runMetrics) report(metricsOutput string, dryRun bool) error {
+	geositegen.Info("metrics",
+		geositegen.F("fetch", time.Duration(m.Fetch)),
+		geositegen.F("download", time.Duration(m.Download)),
+		geositegen.F("parse", time.Duration(m.Parse)),
+		geositegen.F("generate", time.Duration(m.Generate)),
+		geositegen.F("total", time.Duration(m.Total)),
+		geositegen.F("downloaded_bytes", m.DownloadedBytes),
+		geositegen.F("written_files", m.WrittenFiles),
+	)
+	if metricsOutput == "" {
+		return nil
+	}
+	if dryRun {
+		geositegen.Info("dry run: skipped metrics file", geositegen.F("path", metricsOutput))
+		return nil
+	}
+	content, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	metricsPath, _ := filepath.Abs(metricsOutput)
+	geositegen.LogFile("write", metricsPath)
+	return geositegen.WriteFileAtomic(metricsOutput, append(content, '\n'))
+}
@@ -0,0 +1,590 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sagernet/sing-box/option"
+	"github.com/sagernet/sing/common"
+	E "github.com/sagernet/sing/common/exceptions"
+
+	"sing-geosite/geositegen"
+)
+
+type Options struct {
+	Config              string                       `json:"-"`
+	Source              option.Listable[string]      `json:"source,omitempty"`
+	SourceTag           string                       `json:"source_tag,omitempty"`
+	ListReleases        bool                         `json:"list_releases,omitempty"`
+	Prerelease          bool                         `json:"prerelease,omitempty"`
+	SourceType          string                       `json:"source_type,omitempty"`
+	LocalDat            string                       `json:"local_dat,omitempty"`
+	Since               string                       `json:"since,omitempty"`
+	Asset               string                       `json:"asset,omitempty"`
+	ChecksumAsset       string                       `json:"checksum_asset,omitempty"`
+	AssetCompression    string                       `json:"asset_compression,omitempty"`
+	AllowUnverified     bool                         `json:"allow_unverified,omitempty"`
+	ChecksumWarn        bool                         `json:"checksum_warn,omitempty"`
+	Destination         string                       `json:"destination,omitempty"`
+	GitHubURL           string                       `json:"github_url,omitempty"`
+	OutputDir           string                       `json:"output_dir,omitempty"`
+	Output              string                       `json:"output,omitempty"`
+	CNOutput            string                       `json:"cn_output,omitempty"`
+	CNRuleSetOutput     string                       `json:"cn_rule_set_output,omitempty"`
+	NoDB                bool                         `json:"no_db,omitempty"`
+	DBShard             string                       `json:"db_shard,omitempty"`
+	DBShardGroups       map[string][]string          `json:"db_shard_groups,omitempty"`
+	DBShardOutput       string                       `json:"db_shard_output,omitempty"`
+	RuleSetOutput       string                       `json:"rule_set_output,omitempty"`
+	Prefix              string                       `json:"prefix,omitempty"`
+	Archive             string                       `json:"archive,omitempty"`
+	Formats             []string                     `json:"formats,omitempty"`
+	JSONIndent          string                       `json:"json_indent,omitempty"`
+	CompatVersion       string                       `json:"compat_version,omitempty"`
+	AttributeSeparator  string                       `json:"attribute_separator,omitempty"`
+	Nested              bool                         `json:"nested,omitempty"`
+	VersionFiles        bool                         `json:"version_files,omitempty"`
+	Text                bool                         `json:"txt,omitempty"`
+	GeoIPRuleSetOutput  string                       `json:"geoip_rule_set_output,omitempty"`
+	IPVersion           string                       `json:"ip_version,omitempty"`
+	SplitIPVersion      bool                         `json:"split_ip_version,omitempty"`
+	ChangesOutput       string                       `json:"changes_output,omitempty"`
+	SummaryFile         string                       `json:"summary_file,omitempty"`
+	MetricsFile         string                       `json:"metrics_file,omitempty"`
+	StatsJSON           string                       `json:"stats_json,omitempty"`
+	CNCodes             []string                     `json:"cn_codes,omitempty"`
+	Include             []string                     `json:"include,omitempty"`
+	SuffixOnly          bool                         `json:"suffix_only,omitempty"`
+	NoRegex             bool                         `json:"no_regex,omitempty"`
+	LintRegex           bool                         `json:"lint_regex,omitempty"`
+	Exclude             []string                     `json:"exclude,omitempty"`
+	CodeRegex           string                       `json:"code_regex,omitempty"`
+	CodeRegexMerged     string                       `json:"code_regex_merged,omitempty"`
+	Require             []string                     `json:"require,omitempty"`
+	Groups              string                       `json:"groups,omitempty"`
+	ExtraDomains        string                       `json:"extra_domains,omitempty"`
+	DenyFile            string                       `json:"deny_file,omitempty"`
+	TypeOverrides       map[string]map[string]string `json:"type_overrides,omitempty"`
+	MaxAttempts         int                          `json:"max_attempts,omitempty"`
+	WaitRateLimit       bool                         `json:"wait_rate_limit,omitempty"`
+	CacheDir            string                       `json:"cache_dir,omitempty"`
+	NoCache             bool                         `json:"no_cache,omitempty"`
+	DownloadTimeout     option.Duration              `json:"download_timeout,omitempty"`
+	DownloadConcurrency int                          `json:"download_concurrency,omitempty"`
+	MaxDownloadBytes    int64                        `json:"max_download_bytes,omitempty"`
+	Timeout             option.Duration              `json:"timeout,omitempty"`
+	Concurrency         int                          `json:"concurrency,omitempty"`
+	Force               bool                         `json:"force,omitempty"`
+	ChangedOnly         bool                         `json:"changed_only,omitempty"`
+	DryRun              bool                         `json:"dry_run,omitempty"`
+	Publish             bool                         `json:"publish,omitempty"`
+	LogFormat           string                       `json:"log_format,omitempty"`
+	Quiet               bool                         `json:"quiet,omitempty"`
+	Verbose             bool                         `json:"verbose,omitempty"`
+	MaxRules            int                          `json:"max_rules,omitempty"`
+	ErrorOnMax          bool                         `json:"error_on_max,omitempty"`
+	All                 bool                         `json:"all,omitempty"`
+	PruneEmpty          bool                         `json:"prune_empty,omitempty"`
+	Except              []string                     `json:"except,omitempty"`
+	Gzip                bool                         `json:"gzip,omitempty"`
+	Sort                bool                         `json:"sort,omitempty"`
+	Optimize            bool                         `json:"optimize,omitempty"`
+	Verify              bool                         `json:"verify,omitempty"`
+	VerifyOutput        bool                         `json:"verify_output,omitempty"`
+	Deterministic       bool                         `json:"deterministic,omitempty"`
+}
+
+func defaultOptions() Options {
+	return Options{
+		Source:             []string{"Loyalsoldier/v2ray-rules-dat"},
+		SourceType:         "dat",
+		Asset:              "geosite.dat",
+		AssetCompression:   "auto",
+		Destination:        "minoriazure/sing-geosite",
+		GitHubURL:          os.Getenv("GITHUB_API_URL"),
+		Output:             "geosite.db",
+		CNOutput:           "geosite-cn.db",
+		CNRuleSetOutput:    "geosite-cn.srs",
+		DBShardOutput:      "geosite-shards",
+		RuleSetOutput:      "rule-set",
+		Prefix:             "geosite-",
+		Formats:            []string{"srs", "json"},
+		JSONIndent:         "    ",
+		AttributeSeparator: "@",
+		ChangesOutput:      "changes.md",
+		IPVersion:          "both",
+		CNCodes: []string{
+			"cn",
+			"geolocation-!cn",
+			"category-companies@cn",
+		},
+		MaxAttempts:         3,
+		DownloadTimeout:     option.Duration(60 * time.Second),
+		DownloadConcurrency: 4,
+		MaxDownloadBytes:    256 << 20,
+		Concurrency:         runtime.NumCPU(),
+	}
+}
+
+func newFlagSet(options *Options) *flag.FlagSet {
+	flagSet := flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+	flagSet.StringVar(&options.Config, "config", options.Config, "path of a json configuration file, explicit flags override its fields")
+	flagSet.Var(&repeatedValue{list: (*[]string)(&options.Source)}, "source", "upstream repository to fetch geosite.dat from, repeat to merge the codes of several repositories in order, the first one decides whether to skip")
+	flagSet.StringVar(&options.SourceTag, "source-tag", options.SourceTag, "upstream release tag to build from, the latest release if empty")
+	flagSet.BoolVar(&options.ListReleases, "list-releases", options.ListReleases, "take the newest non-draft upstream release from the release list instead of the one github reports as latest")
+	flagSet.BoolVar(&options.Prerelease, "prerelease", options.Prerelease, "accept prereleases with list-releases")
+	flagSet.StringVar(&options.SourceType, "source-type", options.SourceType, "format of the geosite asset, dat or archive of plain-text domain lists")
+	flagSet.StringVar(&options.LocalDat, "local-dat", options.LocalDat, "path of a local geosite asset to build from instead of the upstream release")
+	flagSet.StringVar(&options.Since, "since", options.Since, "skip unless the upstream release was published after this RFC 3339 time, or destination for its latest release")
+	flagSet.StringVar(&options.Asset, "asset", options.Asset, "name of the geosite asset in the upstream release")
+	flagSet.StringVar(&options.AssetCompression, "asset-compression", options.AssetCompression, "compression of the geosite asset, gzip, none or auto to decompress assets named *.gz")
+	flagSet.StringVar(&options.ChecksumAsset, "checksum-asset", options.ChecksumAsset, "name of the checksum asset in the upstream release, the strongest of asset.sha512sum, asset.b2sum and asset.sha256sum if empty")
+	flagSet.BoolVar(&options.AllowUnverified, "allow-unverified", options.AllowUnverified, "proceed without verification if the checksum asset is missing")
+	flagSet.BoolVar(&options.ChecksumWarn, "checksum-warn", options.ChecksumWarn, "warn and proceed instead of failing when an asset does not match its checksum")
+	flagSet.StringVar(&options.Destination, "destination", options.Destination, "repository whose latest release is compared against upstream")
+	flagSet.StringVar(&options.GitHubURL, "github-url", options.GitHubURL, "base url of a GitHub Enterprise API, defaults to GITHUB_API_URL or github.com")
+	flagSet.StringVar(&options.OutputDir, "output-dir", options.OutputDir, "directory every relative output path is placed under, created if missing. Paths expand $VAR and ${VAR}, $$ is a literal $")
+	flagSet.StringVar(&options.Output, "output", options.Output, "path of the generated geosite database")
+	flagSet.StringVar(&options.CNOutput, "cn-output", options.CNOutput, "path of the generated cn geosite database")
+	flagSet.StringVar(&options.CNRuleSetOutput, "cn-rule-set-output", options.CNRuleSetOutput, "path of the binary rule set merging the cn codes, disabled if empty")
+	flagSet.BoolVar(&options.NoDB, "no-db", options.NoDB, "skip the geosite and cn geosite databases")
+	flagSet.StringVar(&options.DBShard, "db-shard", options.DBShard, "also split the geosite database by letter, prefix or group, disabled if empty")
+	flagSet.StringVar(&options.DBShardOutput, "db-shard-output", options.DBShardOutput, "directory of the geosite database shards")
+	flagSet.StringVar(&options.RuleSetOutput, "rule-set-output", options.RuleSetOutput, "directory of the generated rule sets")
+	flagSet.StringVar(&options.Prefix, "prefix", options.Prefix, "file name prefix of the generated geosite rule sets")
+	flagSet.StringVar(&options.Archive, "archive", options.Archive, "path of a .tar.gz or .zip archive bundling the rule set directory, disabled if empty")
+	flagSet.StringVar(&options.CompatVersion, "compat-version", options.CompatVersion, "oldest sing-box version the rule sets must load in, fails if the binary format is newer")
+	flagSet.Var(listValue{&options.Formats}, "formats", "comma separated rule set formats to write, srs and json")
+	flagSet.StringVar(&options.JSONIndent, "json-indent", options.JSONIndent, "indent of the json rule sets, minified if empty")
+	flagSet.StringVar(&options.AttributeSeparator, "attribute-separator", options.AttributeSeparator, "replaces the @ of code@attribute in rule set file names, kept if empty")
+	flagSet.BoolVar(&options.Nested, "nested", options.Nested, "place the rule sets of each code in a subdirectory named after the code up to its first - or @")
+	flagSet.BoolVar(&options.Text, "txt", options.Text, "also write each geosite rule set as a plain-text list with full:, domain:, keyword: and regexp: prefixes")
+	flagSet.BoolVar(&options.VersionFiles, "version-files", options.VersionFiles, "write the upstream release tag to a .version file next to each rule set")
+	flagSet.StringVar(&options.GeoIPRuleSetOutput, "geoip-rule-set-output", options.GeoIPRuleSetOutput, "directory of the rule sets generated from geoip.dat, disabled if empty")
+	flagSet.StringVar(&options.IPVersion, "ip-version", options.IPVersion, "address family kept in geoip rule sets, 4, 6 or both")
+	flagSet.BoolVar(&options.SplitIPVersion, "split-ip-version", options.SplitIPVersion, "also write geoip-<code>-v4 and geoip-<code>-v6 for codes mixing both families")
+	flagSet.StringVar(&options.ChangesOutput, "changes-output", options.ChangesOutput, "path of the markdown change report, disabled if empty")
+	flagSet.StringVar(&options.SummaryFile, "summary-file", options.SummaryFile, "path of a markdown run summary suitable as a pull request body, disabled if empty")
+	flagSet.StringVar(&options.MetricsFile, "metrics-file", options.MetricsFile, "path of a json file receiving the timing summary, disabled if empty")
+	flagSet.StringVar(&options.StatsJSON, "stats-json", options.StatsJSON, "path of a json file receiving the item counts of each type per code, disabled if empty")
+	flagSet.Var(listValue{&options.CNCodes}, "cn-codes", "comma separated codes or code@attribute written to the cn geosite database")
+	flagSet.BoolVar(&options.SuffixOnly, "suffix-only", options.SuffixOnly, "convert root domains into a single suffix matching the domain itself, instead of an exact domain and a .suffix")
+	flagSet.BoolVar(&options.NoRegex, "no-regex", options.NoRegex, "skip regular expression domains, which are expensive to match")
+	flagSet.BoolVar(&options.LintRegex, "lint-regex", options.LintRegex, "warn about regular expression domains which may match differently than intended")
+	flagSet.Var(listValue{&options.Include}, "include", "comma separated glob patterns of codes to generate, all codes if empty")
+	flagSet.StringVar(&options.Groups, "groups", options.Groups, "path of a json object mapping extra codes to the codes they merge")
+	flagSet.StringVar(&options.ExtraDomains, "extra-domains", options.ExtraDomains, "path of a json object mapping codes to domains added to them, in the domain-list-community syntax")
+	flagSet.StringVar(&options.DenyFile, "deny-file", options.DenyFile, "path of a list of domains, one per line in the domain-list-community syntax, removed from every code")
+	flagSet.Var(listValue{&options.Exclude}, "exclude", "comma separated glob patterns of codes to skip, applied after include")
+	flagSet.StringVar(&options.CodeRegex, "code-regex", options.CodeRegex, "regular expression codes must also match to be generated")
+	flagSet.StringVar(&options.CodeRegexMerged, "code-regex-merged", options.CodeRegexMerged, "also write <prefix><name> holding the domains of every code selected by code-regex")
+	flagSet.Var(listValue{&options.Require}, "require", "comma separated codes which must exist upstream or the build fails")
+	flagSet.IntVar(&options.MaxAttempts, "max-attempts", options.MaxAttempts, "maximum attempts of each download")
+	flagSet.BoolVar(&options.WaitRateLimit, "wait-rate-limit", options.WaitRateLimit, "sleep until the github api rate limit resets instead of failing")
+	flagSet.StringVar(&options.CacheDir, "cache-dir", options.CacheDir, "directory caching downloaded assets, defaults to the user cache directory")
+	flagSet.BoolVar(&options.NoCache, "no-cache", options.NoCache, "always download assets instead of using the cache")
+	flagSet.DurationVar((*time.Duration)(&options.DownloadTimeout), "download-timeout", time.Duration(options.DownloadTimeout), "timeout of connecting, awaiting the response and each read of a download attempt")
+	flagSet.IntVar(&options.DownloadConcurrency, "concurrency-downloads", options.DownloadConcurrency, "maximum downloads in flight at once")
+	flagSet.IntVar(&options.DownloadConcurrency, "download-concurrency", options.DownloadConcurrency, "alias of -concurrency-downloads")
+	flagSet.Int64Var(&options.MaxDownloadBytes, "max-download-bytes", options.MaxDownloadBytes, "fail downloads larger than this many bytes, unlimited if zero")
+	flagSet.DurationVar((*time.Duration)(&options.Timeout), "timeout", time.Duration(options.Timeout), "deadline of the whole run, disabled if zero")
+	flagSet.IntVar(&options.Concurrency, "concurrency-writes", options.Concurrency, "number of rule sets written in parallel")
+	flagSet.IntVar(&options.Concurrency, "concurrency", options.Concurrency, "alias of -concurrency-writes")
+	flagSet.BoolVar(&options.Force, "force", options.Force, "rewrite every rule set even if its content is unchanged")
+	flagSet.BoolVar(&options.ChangedOnly, "changed-only", options.ChangedOnly, "keep the rule sets of codes whose items did not change since the last run instead of rebuilding them")
+	flagSet.BoolVar(&options.DryRun, "dry-run", options.DryRun, "report what would change without writing anything")
+	flagSet.BoolVar(&options.Publish, "publish", options.Publish, "create or update the destination release tagged as the source release and upload the databases and archive to it")
+	flagSet.IntVar(&options.MaxRules, "max-rules", options.MaxRules, "warn when a code has more rules than this, disabled if zero")
+	flagSet.BoolVar(&options.ErrorOnMax, "error-on-max", options.ErrorOnMax, "fail instead of warning when a code exceeds max-rules")
+	flagSet.BoolVar(&options.Verify, "verify", options.Verify, "check that every json rule set decodes back to the same content")
+	flagSet.BoolVar(&options.VerifyOutput, "verify-output", options.VerifyOutput, "read every written binary rule set back and fail if it does not decode to the same domain items")
+	flagSet.BoolVar(&options.Deterministic, "deterministic", options.Deterministic, "omit build timestamps so that identical inputs give identical files")
+	flagSet.BoolVar(&options.Optimize, "optimize", options.Optimize, "drop domain suffixes covered by a shorter suffix of the same rule set")
+	flagSet.BoolVar(&options.Sort, "sort", options.Sort, "sort the domains of each rule set for byte-stable output")
+	flagSet.BoolVar(&options.Gzip, "gzip", options.Gzip, "also write a gzip-compressed copy of each .srs file")
+	flagSet.BoolVar(&options.PruneEmpty, "prune-empty", options.PruneEmpty, "skip the rule sets of codes left without items")
+	flagSet.BoolVar(&options.All, "all", options.All, "also write <prefix>all, the union of every code")
+	flagSet.Var(listValue{&options.Except}, "except", "comma separated codes, also write <prefix>all-except holding the domains of every code but those")
+	flagSet.StringVar(&options.LogFormat, "log-format", options.LogFormat, "log format, text or json")
+	flagSet.BoolVar(&options.Quiet, "quiet", options.Quiet, "do not log each file written or removed, only the summary")
+	flagSet.BoolVar(&options.Verbose, "verbose", options.Verbose, "log each file written or removed, the default unless quiet")
+	return flagSet
+}
+
+func parseOptions(arguments []string) (Options, error) {
+	options := defaultOptions()
+	flagSet := newFlagSet(&options)
+	err := flagSet.Parse(arguments)
+	if err != nil {
+		return Options{}, err
+	}
+	if options.Config != "" {
+		configPath := options.Config
+		options, err = readOptions(configPath)
+		if err != nil {
+			return Options{}, err
+		}
+		options.Config = configPath
+		flagSet = newFlagSet(&options)
+		err = flagSet.Parse(arguments)
+		if err != nil {
+			return Options{}, err
+		}
+	}
+	options.expandPaths()
+	options.normalizeCodes()
+	err = options.validate()
+	if err != nil {
+		return Options{}, err
+	}
+	options.resolveOutputs()
+	return options, nil
+}
+
+func (o *Options) outputPaths() []*string {
+	return []*string{
+		&o.Output,
+		&o.CNOutput,
+		&o.CNRuleSetOutput,
+		&o.DBShardOutput,
+		&o.RuleSetOutput,
+		&o.Archive,
+		&o.GeoIPRuleSetOutput,
+		&o.ChangesOutput,
+		&o.SummaryFile,
+		&o.MetricsFile,
+		&o.StatsJSON,
+	}
+}
+
+// expandPaths replaces $VAR and ${VAR} in the configured paths with the
+// value of the environment variable, $$ stands for a literal $.
+func (o *Options) expandPaths() {
+	for _, configuredPath := range append(o.outputPaths(), &o.OutputDir, &o.CacheDir, &o.LocalDat, &o.Groups, &o.ExtraDomains, &o.DenyFile) {
+		*configuredPath = os.Expand(*configuredPath, func(name string) string {
+			if name == "$" {
+				return "$"
+			}
+			return os.Getenv(name)
+		})
+	}
+}
+
+// gunzipAsset reports whether the geosite asset is gzip-compressed.
+func (o Options) gunzipAsset() bool {
+	if o.AssetCompression == "auto" {
+		return strings.HasSuffix(o.Asset, ".gz")
+	}
+	return o.AssetCompression == "gzip"
+}
+
+// normalizeCodes lowercases the code patterns, codes are lowercase once
+// parsed.
+func (o *Options) normalizeCodes() {
+	for _, patterns := range [][]string{o.Include, o.Exclude} {
+		for i, pattern := range patterns {
+			patterns[i] = strings.ToLower(pattern)
+		}
+	}
+}
+
+// resolveOutputs places the relative output paths under OutputDir.
+func (o *Options) resolveOutputs() {
+	if o.OutputDir == "" {
+		return
+	}
+	for _, outputPath := range o.outputPaths() {
+		if *outputPath != "" && !filepath.IsAbs(*outputPath) {
+			*outputPath = filepath.Join(o.OutputDir, *outputPath)
+		}
+	}
+}
+
+func readOptions(path string) (Options, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return Options{}, E.Cause(err, "read config")
+	}
+	options := defaultOptions()
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	decoder.DisallowUnknownFields()
+	err = decoder.Decode(&options)
+	if err != nil {
+		return Options{}, E.Cause(err, "decode config ", path)
+	}
+	return options, nil
+}
+
+func (o Options) validate() error {
+	if len(o.Source) == 0 {
+		return E.New("invalid options: source: missing repository")
+	}
+	var err error
+	for _, source := range o.Source {
+		err = checkRepository("source", source)
+		if err != nil {
+			return err
+		}
+	}
+	err = checkRepository("destination", o.Destination)
+	if err != nil {
+		return err
+	}
+	if o.Archive != "" && o.RuleSetOutput != "" {
+		if filepath.Clean(o.Archive) == filepath.Clean(o.RuleSetOutput) || isWithin(o.RuleSetOutput, o.Archive) {
+			return E.New("invalid options: archive: ", o.Archive, ", expected a path outside rule_set_output")
+		}
+	}
+	if o.Publish && o.LocalDat != "" {
+		return E.New("invalid options: publish: requires an upstream release, not local_dat")
+	}
+	if o.ChangedOnly && o.Force {
+		return E.New("invalid options: changed_only: conflicts with force")
+	}
+	if o.Prerelease && !o.ListReleases {
+		return E.New("invalid options: prerelease: requires list_releases")
+	}
+	if o.SourceType != "dat" && o.SourceType != "archive" {
+		return E.New("invalid options: source_type: ", o.SourceType, ", expected dat or archive")
+	}
+	if o.Since != "" && o.Since != "destination" {
+		_, err = time.Parse(time.RFC3339, o.Since)
+		if err != nil {
+			return E.New("invalid options: since: ", o.Since, ", expected an RFC 3339 time or destination")
+		}
+	}
+	if o.Asset == "" {
+		return E.New("invalid options: asset: empty name")
+	}
+	switch o.AssetCompression {
+	case "auto", "gzip", "none":
+	default:
+		return E.New("invalid options: asset_compression: ", o.AssetCompression, ", expected auto, gzip or none")
+	}
+	if o.Output == "" {
+		return E.New("invalid options: output: empty path")
+	}
+	if o.CNOutput == "" {
+		return E.New("invalid options: cn_output: empty path")
+	}
+	switch o.DBShard {
+	case "", "letter", "prefix":
+	case "group":
+		if len(o.DBShardGroups) == 0 {
+			return E.New("invalid options: db_shard_groups: required by db_shard group")
+		}
+	default:
+		return E.New("invalid options: db_shard: ", o.DBShard, ", expected letter, prefix or group")
+	}
+	for code, overrides := range o.TypeOverrides {
+		for from, to := range overrides {
+			_, fromLoaded := geositegen.ParseDomainType(from)
+			_, toLoaded := geositegen.ParseDomainType(to)
+			if !fromLoaded || !toLoaded {
+				return E.New("invalid options: type_overrides[", code, "]: ", from, " to ", to, ", expected domain, full, keyword or regexp")
+			}
+		}
+	}
+	for name, patterns := range o.DBShardGroups {
+		if name == "" {
+			return E.New("invalid options: db_shard_groups: empty group name")
+		}
+		for i, pattern := range patterns {
+			_, err = path.Match(pattern, "")
+			if err != nil {
+				return E.New("invalid options: db_shard_groups[", name, "][", i, "]: ", pattern, ": ", err)
+			}
+		}
+	}
+	if o.DBShard != "" && o.DBShardOutput == "" {
+		return E.New("invalid options: db_shard_output: empty path")
+	}
+	if o.RuleSetOutput == "" {
+		return E.New("invalid options: rule_set_output: empty path")
+	}
+	if o.CompatVersion != "" {
+		older, err := versionLess(o.CompatVersion, geositegen.MinClientVersion)
+		if err != nil {
+			return E.Cause(err, "invalid options: compat_version")
+		}
+		if older {
+			return E.New("invalid options: compat_version: ", o.CompatVersion, ", binary rule sets require sing-box ", geositegen.MinClientVersion, " or later")
+		}
+	}
+	if len(o.Formats) == 0 {
+		return E.New("invalid options: formats: empty list")
+	}
+	for i, format := range o.Formats {
+		if format != "srs" && format != "json" {
+			return E.New("invalid options: formats[", i, "]: ", format, ", expected srs or json")
+		}
+	}
+	if strings.Trim(o.JSONIndent, " \t") != "" {
+		return E.New("invalid options: json_indent: ", strconv.Quote(o.JSONIndent), ", expected spaces or tabs")
+	}
+	if o.Gzip && !common.Contains(o.Formats, "srs") {
+		return E.New("invalid options: gzip: requires the srs format")
+	}
+	if o.SummaryFile != "" && o.RuleSetOutput == "" {
+		return E.New("invalid options: summary_file: requires rule_set_output")
+	}
+	if strings.ContainsAny(o.AttributeSeparator, "/\\") {
+		return E.New("invalid options: attribute_separator: ", o.AttributeSeparator, ", expected no path separators")
+	}
+	if o.IPVersion != "4" && o.IPVersion != "6" && o.IPVersion != "both" {
+		return E.New("invalid options: ip_version: ", o.IPVersion, ", expected 4, 6 or both")
+	}
+	for i, code := range o.CNCodes {
+		if code == "" {
+			return E.New("invalid options: cn_codes[", i, "]: empty code")
+		}
+		if name, attribute, isAttribute := strings.Cut(code, "@"); isAttribute && (name == "" || attribute == "") {
+			return E.New("invalid options: cn_codes[", i, "]: ", code, ", expected code or code@attribute")
+		}
+	}
+	for i, pattern := range o.Include {
+		_, err = path.Match(pattern, "")
+		if err != nil {
+			return E.New("invalid options: include[", i, "]: ", pattern, ": ", err)
+		}
+	}
+	for i, pattern := range o.Exclude {
+		_, err = path.Match(pattern, "")
+		if err != nil {
+			return E.New("invalid options: exclude[", i, "]: ", pattern, ": ", err)
+		}
+	}
+	if strings.ContainsAny(o.Prefix, "/\\") {
+		return E.New("invalid options: prefix: ", o.Prefix, ", expected a file name prefix without path separators")
+	}
+	if o.CodeRegex != "" {
+		_, err = regexp.Compile(o.CodeRegex)
+		if err != nil {
+			return E.New("invalid options: code_regex: ", o.CodeRegex, ": ", err)
+		}
+	}
+	if o.CodeRegexMerged != "" {
+		if o.CodeRegex == "" {
+			return E.New("invalid options: code_regex_merged: requires code_regex")
+		}
+		if strings.ContainsAny(o.CodeRegexMerged, "/\\") {
+			return E.New("invalid options: code_regex_merged: ", o.CodeRegexMerged, ", expected a name without path separators")
+		}
+	}
+	if o.Quiet && o.Verbose {
+		return E.New("invalid options: quiet: conflicts with verbose")
+	}
+	if o.LogFormat != "" && o.LogFormat != "text" && o.LogFormat != "json" {
+		return E.New("invalid options: log_format: ", o.LogFormat, ", expected text or json")
+	}
+	if o.MaxRules < 0 {
+		return E.New("invalid options: max_rules: ", o.MaxRules, ", expected zero or more")
+	}
+	if o.MaxAttempts < 1 {
+		return E.New("invalid options: max_attempts: ", o.MaxAttempts, ", expected at least 1")
+	}
+	if o.DownloadTimeout <= 0 {
+		return E.New("invalid options: download_timeout: ", time.Duration(o.DownloadTimeout), ", expected a positive duration")
+	}
+	if o.MaxDownloadBytes < 0 {
+		return E.New("invalid options: max_download_bytes: ", o.MaxDownloadBytes, ", expected at least 0")
+	}
+	if o.DownloadConcurrency < 1 {
+		return E.New("invalid options: download_concurrency: ", o.DownloadConcurrency, ", expected at least 1")
+	}
+	if o.Timeout < 0 {
+		return E.New("invalid options: timeout: ", time.Duration(o.Timeout), ", expected zero or a positive duration")
+	}
+	if o.Concurrency < 1 {
+		return E.New("invalid options: concurrency: ", o.Concurrency, ", expected at least 1")
+	}
+	return nil
+}
+
+type listValue struct {
+	list *[]string
+}
+
+func (v listValue) String() string {
+	if v.list == nil {
+		return ""
+	}
+	return strings.Join(*v.list, ",")
+}
+
+func (v listValue) Set(value string) error {
+	*v.list = common.Filter(strings.Split(value, ","), func(it string) bool {
+		return it != ""
+	})
+	return nil
+}
+
+// repeatedValue collects a flag given several times, replacing the default
+// on its first occurrence.
+type repeatedValue struct {
+	list *[]string
+	set  bool
+}
+
+func (v *repeatedValue) String() string {
+	if v.list == nil {
+		return ""
+	}
+	return strings.Join(*v.list, ",")
+}
+
+func (v *repeatedValue) Set(value string) error {
+	if !v.set {
+		*v.list = nil
+		v.set = true
+	}
+	*v.list = append(*v.list, value)
+	return nil
+}
+
+// versionLess compares two major.minor.patch versions, ignoring a leading v
+// and any pre-release suffix.
+func versionLess(version string, than string) (bool, error) {
+	parse := func(version string) ([3]int, error) {
+		var numbers [3]int
+		version, _, _ = strings.Cut(strings.TrimPrefix(version, "v"), "-")
+		for i, field := range strings.SplitN(version, ".", 3) {
+			number, err := strconv.Atoi(field)
+			if err != nil {
+				return numbers, E.New("invalid version ", version)
+			}
+			numbers[i] = number
+		}
+		return numbers, nil
+	}
+	versionNumbers, err := parse(version)
+	if err != nil {
+		return false, err
+	}
+	thanNumbers, err := parse(than)
+	if err != nil {
+		return false, err
+	}
+	for i := range versionNumbers {
+		if versionNumbers[i] != thanNumbers[i] {
+			return versionNumbers[i] < thanNumbers[i], nil
+		}
+	}
+	return false, nil
+}
+
+func checkRepository(name string, repository string) error {
+	if strings.Count(repository, "/") != 1 {
+		return E.New("invalid options: ", name, ": ", repository, ", expected owner/name")
+	}
+	return nil
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"runtime/debug"
+	"time"
+
+	C "github.com/sagernet/sing-box/constant"
+
+	"sing-geosite/geositegen"
+)
+
+// provenance records where the rule sets of a run were built from, written as
+// provenance.json next to them.
+type provenance struct {
+	Sources        []provenanceSource `json:"sources"`
+	ToolVersion    string             `json:"tool_version"`
+	SingBoxVersion string             `json:"sing_box_version"`
+	BuildTime      string             `json:"build_time,omitempty"`
+}
+
+type provenanceSource struct {
+	Repository string `json:"repository"`
+	Tag        string `json:"tag"`
+	Commit     string `json:"commit,omitempty"`
+	Asset      string `json:"asset"`
+	SHA256     string `json:"sha256"`
+	// Duplicates counts the duplicate items removed from each code of the
+	// source while parsing.
+	Duplicates map[string]int `json:"-"`
+	// PublishedAt is when the source release was published, zero for a local
+	// file.
+	PublishedAt time.Time `json:"-"`
+}
+
+// writeProvenance writes provenance.json, without the build time if
+// deterministic is set.
+func writeProvenance(ruleSetWriter *geositegen.RuleSetWriter, sources []provenanceSource, deterministic bool) error {
+	var buildTime string
+	if !deterministic {
+		buildTime = time.Now().UTC().Format(time.RFC3339)
+	}
+	var buffer bytes.Buffer
+	je := json.NewEncoder(&buffer)
+	je.SetEscapeHTML(false)
+	je.SetIndent("", "    ")
+	err := je.Encode(provenance{
+		Sources:        sources,
+		ToolVersion:    toolVersion(),
+		SingBoxVersion: C.Version,
+		BuildTime:      buildTime,
+	})
+	if err != nil {
+		return err
+	}
+	return ruleSetWriter.WriteFile("provenance.json", buffer.Bytes())
+}
+
+func toolVersion() string {
+	info, loaded := debug.ReadBuildInfo()
+	if !loaded || info.Main.Version == "" {
+		return "unknown"
+	}
+	return info.Main.Version
+}
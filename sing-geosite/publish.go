@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	E "github.com/sagernet/sing/common/exceptions"
+
+	"github.com/google/go-github/v45/github"
+	"sing-geosite/geositegen"
+)
+
+// publish creates or updates the destination release tagged as the source
+// release and uploads the generated databases and archive to it, replacing the
+// assets of the same name.
+func publish(ctx context.Context, sourceRelease *github.RepositoryRelease, checksum string, options Options) error {
+	if !githubAuthenticated {
+		return E.New("publish requires ACCESS_TOKEN")
+	}
+	owner, repository, _ := strings.Cut(options.Destination, "/")
+	tag := sourceRelease.GetTagName()
+	body := "source-tag: " + tag + "\nsource-sha256: " + checksum
+	release, response, err := githubClient.Repositories.GetReleaseByTag(ctx, owner, repository, tag)
+	logRateLimit(response)
+	if err != nil {
+		if response == nil || response.StatusCode != http.StatusNotFound {
+			return E.Cause(err, "get release ", tag, " of ", options.Destination)
+		}
+		release, response, err = githubClient.Repositories.CreateRelease(ctx, owner, repository, &github.RepositoryRelease{
+			TagName: github.String(tag),
+			Name:    github.String(sourceRelease.GetName()),
+			Body:    github.String(body),
+		})
+		logRateLimit(response)
+		if err != nil {
+			return E.Cause(err, "create release ", tag, " of ", options.Destination)
+		}
+		geositegen.Info("created release", geositegen.F("repository", options.Destination), geositegen.F("tag", tag))
+	} else {
+		assets := release.Assets
+		release, response, err = githubClient.Repositories.EditRelease(ctx, owner, repository, release.GetID(), &github.RepositoryRelease{
+			Body: github.String(body),
+		})
+		logRateLimit(response)
+		if err != nil {
+			return E.Cause(err, "update release ", tag, " of ", options.Destination)
+		}
+		release.Assets = assets
+		geositegen.Info("updated release", geositegen.F("repository", options.Destination), geositegen.F("tag", tag))
+	}
+	assetPaths := publishAssets(options)
+	if len(assetPaths) == 0 {
+		geositegen.Warn("nothing to publish")
+	}
+	for _, assetPath := range assetPaths {
+		err = uploadAsset(ctx, owner, repository, release, assetPath)
+		if err != nil {
+			return E.Cause(err, "upload ", assetPath)
+		}
+	}
+	return nil
+}
+
+// publishAssets returns the generated files uploaded by publish.
+func publishAssets(options Options) []string {
+	var candidates []string
+	if !options.NoDB {
+		candidates = append(candidates, options.Output, options.Output+".sha256sum", options.CNOutput, options.CNOutput+".sha256sum")
+	}
+	if options.CNRuleSetOutput != "" {
+		candidates = append(candidates, options.CNRuleSetOutput)
+	}
+	if options.Archive != "" {
+		candidates = append(candidates, options.Archive)
+	}
+	var assetPaths []string
+	for _, candidate := range candidates {
+		if _, err := os.Stat(candidate); err == nil {
+			assetPaths = append(assetPaths, candidate)
+		}
+	}
+	return assetPaths
+}
+
+func uploadAsset(ctx context.Context, owner string, repository string, release *github.RepositoryRelease, assetPath string) error {
+	name := filepath.Base(assetPath)
+	for _, asset := range release.Assets {
+		if asset.GetName() != name {
+			continue
+		}
+		response, err := githubClient.Repositories.DeleteReleaseAsset(ctx, owner, repository, asset.GetID())
+		logRateLimit(response)
+		if err != nil {
+			return E.Cause(err, "delete previous asset")
+		}
+	}
+	file, err := os.Open(assetPath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	absPath, _ := filepath.Abs(assetPath)
+	geositegen.LogFile("upload", absPath)
+	_, response, err := githubClient.Repositories.UploadReleaseAsset(ctx, owner, repository, release.GetID(), &github.UploadOptions{Name: name}, file)
+	logRateLimit(response)
+	return err
+}
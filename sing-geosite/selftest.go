@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"io"
+	"os"
+
+	E "github.com/sagernet/sing/common/exceptions"
+
+	"sing-geosite/geositegen"
+)
+
+// selftestCodes are well-known codes every upstream release is expected to
+// carry.
+var selftestCodes = []string{"cn", "google"}
+
+// selftest downloads and parses the latest release of the first source, then
+// compiles and encodes the selftestCodes in memory and fails if any is
+// missing or empty. Nothing is written.
+func selftest(ctx context.Context, options Options) error {
+	release, err := fetch(ctx, options.Source[0], options.SourceTag, latestSelection{
+		list:       options.ListReleases,
+		prerelease: options.Prerelease,
+	}, options.WaitRateLimit)
+	if err != nil {
+		return err
+	}
+	assets, err := newDownloader(options).DownloadAll(ctx, []geositegen.DownloadRequest{{
+		Release:       release,
+		Asset:         options.Asset,
+		ChecksumAsset: options.ChecksumAsset,
+		Gunzip:        options.gunzipAsset(),
+	}})
+	if err != nil {
+		return err
+	}
+	defer os.Remove(assets[0].Path)
+	domainMap, _, err := loadSource(options.Source[0], release, assets[0], options)
+	if err != nil {
+		return E.Cause(err, "load ", release.GetHTMLURL())
+	}
+	for _, code := range selftestCodes {
+		domains, loaded := domainMap[code]
+		if !loaded {
+			return E.New("missing code ", code, " in ", release.GetTagName())
+		}
+		headlessRule := geositegen.Compile(domains)
+		ruleCount := geositegen.RuleCount(headlessRule)
+		if ruleCount == 0 {
+			return E.New("empty code ", code, " in ", release.GetTagName())
+		}
+		err = geositegen.WriteSRS(io.Discard, headlessRule)
+		if err != nil {
+			return E.Cause(err, "encode ", code)
+		}
+		geositegen.Info("selftest", geositegen.F("code", code), geositegen.F("rules", ruleCount))
+	}
+	geositegen.Info("selftest passed", geositegen.F("tag", release.GetTagName()), geositegen.F("codes", len(domainMap)))
+	return nil
+}
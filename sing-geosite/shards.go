@@ -0,0 +1,49 @@
+package main
+
+import (
+	"bytes"
+
+	"github.com/sagernet/sing-box/common/geosite"
+
+	"sing-geosite/geositegen"
+)
+
+// shardKey returns the sharding key selected by options, nil if sharding is
+// disabled.
+func shardKey(options Options) geositegen.ShardKey {
+	switch options.DBShard {
+	case "letter":
+		return geositegen.LetterShardKey
+	case "prefix":
+		return geositegen.PrefixShardKey
+	case "group":
+		return geositegen.GroupShardKey(options.DBShardGroups)
+	}
+	return nil
+}
+
+// writeShards writes domainMap split by key as geosite-<shard>.db files in
+// options.DBShardOutput and returns the number of files written.
+func writeShards(domainMap map[string][]geosite.Item, key geositegen.ShardKey, options Options) (int, error) {
+	shardWriter, err := geositegen.NewRuleSetWriter(options.DBShardOutput, options.Force)
+	if err != nil {
+		return 0, err
+	}
+	shards := geositegen.Shard(domainMap, key)
+	for _, shardName := range geositegen.SortedCodes(shards) {
+		var buffer bytes.Buffer
+		err = geosite.Write(&buffer, shards[shardName])
+		if err != nil {
+			return 0, err
+		}
+		err = shardWriter.WriteFile("geosite-"+shardName+".db", buffer.Bytes())
+		if err != nil {
+			return 0, err
+		}
+	}
+	err = shardWriter.Close()
+	if err != nil {
+		return 0, err
+	}
+	return shardWriter.WrittenFiles(), nil
+}
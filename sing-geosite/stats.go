@@ -0,0 +1,50 @@
+package main
+
+import (
+	"encoding/json"
+	"path/filepath"
+
+	"github.com/sagernet/sing-box/common/geosite"
+
+	"sing-geosite/geositegen"
+)
+
+// codeStats counts the items of each type of a code after deduplication, and
+// the duplicates removed from the code by each source.
+type codeStats struct {
+	Domain        int `json:"domain"`
+	DomainSuffix  int `json:"domain_suffix"`
+	DomainKeyword int `json:"domain_keyword"`
+	DomainRegex   int `json:"domain_regex"`
+	Duplicates    int `json:"duplicates"`
+}
+
+// writeStats writes the codeStats of every code of domainMap as a json
+// object sorted by code.
+func writeStats(domainMap map[string][]geosite.Item, sources []provenanceSource, statsOutput string) error {
+	stats := make(map[string]codeStats, len(domainMap))
+	for code, domains := range domainMap {
+		headlessRule := geositegen.Compile(domains)
+		stats[code] = codeStats{
+			Domain:        len(headlessRule.Domain),
+			DomainSuffix:  len(headlessRule.DomainSuffix),
+			DomainKeyword: len(headlessRule.DomainKeyword),
+			DomainRegex:   len(headlessRule.DomainRegex),
+		}
+	}
+	for _, source := range sources {
+		for code, duplicates := range source.Duplicates {
+			if codeStat, loaded := stats[code]; loaded {
+				codeStat.Duplicates += duplicates
+				stats[code] = codeStat
+			}
+		}
+	}
+	content, err := json.MarshalIndent(stats, "", "  ")
+	if err != nil {
+		return err
+	}
+	statsPath, _ := filepath.Abs(statsOutput)
+	geositegen.LogFile("write", statsPath)
+	return geositegen.WriteFileAtomic(statsOutput, append(content, '\n'))
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bytes"
+	"os"
+
+	"github.com/sagernet/sing-box/common/srs"
+	C "github.com/sagernet/sing-box/constant"
+	"github.com/sagernet/sing-box/option"
+	E "github.com/sagernet/sing/common/exceptions"
+
+	"sing-geosite/geositegen"
+)
+
+// validate checks the header of a binary rule set, decodes it and reports the
+// number of items of each type. Decoding is lossy, domains and suffixes are
+// only kept as a matcher and IP CIDR items are merged, so the rules carrying a
+// domain matcher are counted instead and the IP CIDR count is that of the
+// merged prefixes.
+func validate(path string) error {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if len(content) < len(srs.MagicBytes)+1 || !bytes.Equal(content[:len(srs.MagicBytes)], srs.MagicBytes[:]) {
+		return E.New("rule set ", path, ": missing SRS header")
+	}
+	if version := content[len(srs.MagicBytes)]; version != geositegen.RuleSetFormatVersion {
+		return E.New("rule set ", path, ": unsupported version ", version, ", expected ", geositegen.RuleSetFormatVersion)
+	}
+	plainRuleSet, err := srs.Read(bytes.NewReader(content), true)
+	if err != nil {
+		return E.Cause(err, "decode ", path)
+	}
+	var counts ruleTypeCounts
+	for _, rule := range plainRuleSet.Rules {
+		counts.add(rule)
+	}
+	geositegen.Info("valid rule set", geositegen.F("path", path),
+		geositegen.F("rules", len(plainRuleSet.Rules)),
+		geositegen.F("logical", counts.logical),
+		geositegen.F("domain_matcher", counts.domainMatcher),
+		geositegen.F("domain_keyword", counts.domainKeyword),
+		geositegen.F("domain_regex", counts.domainRegex),
+		geositegen.F("ip_cidr", counts.ipCIDR),
+		geositegen.F("other", counts.other),
+	)
+	return nil
+}
+
+type ruleTypeCounts struct {
+	logical       int
+	domainMatcher int
+	domainKeyword int
+	domainRegex   int
+	ipCIDR        int
+	other         int
+}
+
+func (c *ruleTypeCounts) add(rule option.HeadlessRule) {
+	if rule.Type == C.RuleTypeLogical {
+		c.logical++
+		for _, subRule := range rule.LogicalOptions.Rules {
+			c.add(subRule)
+		}
+		return
+	}
+	defaultRule := rule.DefaultOptions
+	if defaultRule.DomainMatcher != nil {
+		c.domainMatcher++
+	}
+	c.domainKeyword += len(defaultRule.DomainKeyword)
+	c.domainRegex += len(defaultRule.DomainRegex)
+	c.ipCIDR += len(defaultRule.IPCIDR)
+	c.other += len(defaultRule.QueryType) +
+		len(defaultRule.Network) +
+		len(defaultRule.SourceIPCIDR) +
+		len(defaultRule.SourcePort) +
+		len(defaultRule.SourcePortRange) +
+		len(defaultRule.Port) +
+		len(defaultRule.PortRange) +
+		len(defaultRule.ProcessName) +
+		len(defaultRule.ProcessPath) +
+		len(defaultRule.PackageName) +
+		len(defaultRule.WIFISSID) +
+		len(defaultRule.WIFIBSSID)
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/sagernet/sing-box/option"
+
+	"sing-geosite/geositegen"
+)
+
+func TestValidate(t *testing.T) {
+	output := t.TempDir()
+	ruleSetWriter, err := geositegen.NewRuleSetWriter(output, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = ruleSetWriter.WriteRuleSet("geosite-test", option.DefaultHeadlessRule{
+		Domain:        []string{"example.com"},
+		DomainSuffix:  []string{".example.com"},
+		DomainKeyword: []string{"keyword"},
+		DomainRegex:   []string{`^regex\.example\.com$`},
+		IPCIDR:        []string{"192.0.2.0/25", "192.0.2.128/25"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = validate(filepath.Join(output, "geosite-test.srs"))
+	if err != nil {
+		t.Fatal(err)
+	}
+}